@@ -0,0 +1,423 @@
+package psremote
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const clixmlHeader = "#< CLIXML"
+
+// Stream names used in CLIXML S attributes.
+const (
+	clixmlError       = "error"
+	clixmlWarning     = "warning"
+	clixmlVerbose     = "verbose"
+	clixmlDebug       = "debug"
+	clixmlProgress    = "progress"
+	clixmlInformation = "information"
+)
+
+// errorInfoFunction defines Get-PSRemoteErrorInfo, which returns the
+// properties of an error record that Write-PSRemoteError writes. It is
+// also defined in remote script blocks, so that a terminating error is
+// described where it was thrown rather than from its deserialised copy.
+const errorInfoFunction = `
+function Get-PSRemoteErrorInfo($record, [bool]$terminating) {
+	$exceptionType = $record.Exception.GetType().FullName
+	if ($record.Exception.SerializedRemoteException) {
+		$exceptionType = $record.Exception.SerializedRemoteException.PSObject.TypeNames[0] -replace '^Deserialized\.', ''
+	}
+	$targetObject = $null
+	if ($null -ne $record.TargetObject) {
+		$targetObject = "$($record.TargetObject)"
+	}
+	[pscustomobject]@{
+		Message = $record.ToString()
+		FullyQualifiedErrorId = $record.FullyQualifiedErrorId
+		Category = $record.CategoryInfo.Category.ToString()
+		Activity = $record.CategoryInfo.Activity
+		Reason = $record.CategoryInfo.Reason
+		TargetName = $record.CategoryInfo.TargetName
+		TargetType = $record.CategoryInfo.TargetType
+		TargetObject = $targetObject
+		ScriptStackTrace = $record.ScriptStackTrace
+		ExceptionType = $exceptionType
+		ComputerName = $record.OriginInfo.PSComputerName
+		Terminating = $terminating
+	}
+}
+`
+
+// errorPreamble wraps a script so that every error record it produces,
+// terminating or not, is written to stderr as a single line of CLIXML.
+// Plain text is all PowerShell writes to stderr otherwise. Warning, verbose
+// and debug records, and the script's own progress, are written to stderr
+// as single prefixed lines so that they stay out of the output.
+const errorPreamble = errorInfoFunction + `
+function Write-PSRemoteError($record, [bool]$terminating) {
+	Write-PSRemoteErrorInfo (Get-PSRemoteErrorInfo $record $terminating)
+}
+
+function Write-PSRemoteErrorInfo($info) {
+	$xml = [System.Management.Automation.PSSerializer]::Serialize($info) -replace '\r?\n\s*', ''
+	[Console]::Error.WriteLine('` + clixmlHeader + ` ' + $xml)
+}
+
+function Write-PSRemoteRecord {
+	process {
+		if ($_ -is [System.Management.Automation.ErrorRecord]) {
+			Write-PSRemoteError $_ $false
+		} elseif ($_ -is [System.Management.Automation.WarningRecord]) {
+			[Console]::Error.WriteLine('WARNING: ' + ($_.Message -replace '\r?\n', ' '))
+		} elseif ($_ -is [System.Management.Automation.VerboseRecord]) {
+			[Console]::Error.WriteLine('VERBOSE: ' + ($_.Message -replace '\r?\n', ' '))
+		} elseif ($_ -is [System.Management.Automation.DebugRecord]) {
+			[Console]::Error.WriteLine('DEBUG: ' + ($_.Message -replace '\r?\n', ' '))
+		} else {
+			$_
+		}
+	}
+}
+
+function Write-Progress {
+	param([string]$Activity, [string]$Status, [int]$Id, [int]$PercentComplete = -1, [int]$SecondsRemaining, [string]$CurrentOperation, [int]$ParentId, [switch]$Completed, [int]$SourceId)
+	$text = $Activity
+	if ($Status) {
+		$text += ': ' + $Status
+	}
+	if ($CurrentOperation) {
+		$text += ', ' + $CurrentOperation
+	}
+	if ($Completed) {
+		$text += ' (completed)'
+	} elseif ($PercentComplete -ge 0) {
+		$text += ' (' + $PercentComplete + '%)'
+	}
+	[Console]::Error.WriteLine('PROGRESS: ' + ($text -replace '\r?\n', ' '))
+}
+`
+
+// wrapErrors runs script with its error, warning, verbose and debug
+// streams redirected through Write-PSRemoteRecord. PowerShell exits with
+// the exit code of the last native command, or the one reported by
+// remoteScriptBlock, which it would otherwise drop.
+func wrapErrors(script string) string {
+	return errorPreamble + `
+$global:LASTEXITCODE = 0
+try {
+	& {
+` + script + `
+	} 2>&1 3>&1 4>&1 5>&1 | Write-PSRemoteRecord
+} catch {
+	Write-PSRemoteError $_ $true
+	exit 1
+}
+exit $global:LASTEXITCODE
+`
+}
+
+// remoteScriptBlock returns an Invoke-Command with the given target
+// parameters running scriptBlock. The remote $LASTEXITCODE is sent back
+// with the output and set locally, since Invoke-Command does not return
+// it. The exit code is sent from a finally block, which still runs after
+// an exit statement in scriptBlock, and exit sets $LASTEXITCODE first. A
+// terminating error would only reach this side as a non-terminating one,
+// so it is caught remotely and sent back with exit code 1, as wrapErrors
+// does locally.
+func remoteScriptBlock(target, scriptBlock string) string {
+	return `Invoke-Command ` + target + ` -ScriptBlock {` + errorInfoFunction + `
+	$global:LASTEXITCODE = 0
+	$psremoteFailed = $false
+	try {
+		& {
+` + scriptBlock + `
+		}
+	} catch {
+		$psremoteFailed = $true
+		[pscustomobject]@{ PSRemoteExitCode = 1; PSRemoteError = (Get-PSRemoteErrorInfo $_ $true) }
+	} finally {
+		if (!$psremoteFailed) {
+			[pscustomobject]@{ PSRemoteExitCode = $global:LASTEXITCODE }
+		}
+	}
+} | ForEach-Object {
+	if ($null -ne $_.PSRemoteExitCode) {
+		if ($_.PSRemoteError) {
+			$info = $_.PSRemoteError
+			if (!$info.ComputerName) {
+				$info.ComputerName = $_.PSComputerName
+			}
+			Write-PSRemoteErrorInfo $info
+		}
+		$global:LASTEXITCODE = $_.PSRemoteExitCode
+	} else {
+		$_
+	}
+}`
+}
+
+// streamRecord is one decoded entry from PowerShell's stderr.
+type streamRecord struct {
+	stream string
+	text   string
+	err    *PSError
+}
+
+// parseStderr splits stderr into records. It understands the single line
+// CLIXML written by wrapErrors, the multi-line CLIXML PowerShell itself
+// emits for encoded commands, and plain text, which is treated as error
+// output.
+func parseStderr(stderr string) []streamRecord {
+	var records []streamRecord
+	var plain []string
+
+	flushPlain := func() {
+		text := strings.TrimSpace(strings.Join(plain, "\n"))
+		if text != "" {
+			records = append(records, streamRecord{stream: clixmlError, text: text})
+		}
+		plain = nil
+	}
+
+	lines := strings.Split(strings.Replace(stderr, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if stream, text, ok := plainStream(line); ok {
+			flushPlain()
+			records = append(records, streamRecord{stream: stream, text: text})
+			continue
+		}
+		if !strings.HasPrefix(line, clixmlHeader) {
+			plain = append(plain, line)
+			continue
+		}
+		flushPlain()
+
+		doc := strings.TrimSpace(strings.TrimPrefix(line, clixmlHeader))
+		for !strings.Contains(doc, "</Objs>") && i+1 < len(lines) {
+			i++
+			doc += lines[i]
+		}
+
+		records = append(records, parseCLIXML(doc)...)
+	}
+	flushPlain()
+
+	return mergeErrorFragments(records)
+}
+
+// plainStream recognises the prefixes PowerShell puts on warning, verbose
+// and debug messages written as text, and the one the Write-Progress proxy
+// in errorPreamble uses.
+func plainStream(line string) (stream, text string, ok bool) {
+	for _, prefix := range []string{"WARNING: ", "VERBOSE: ", "DEBUG: ", "PROGRESS: "} {
+		if strings.HasPrefix(line, prefix) {
+			return strings.ToLower(strings.TrimSuffix(prefix, ": ")), strings.TrimPrefix(line, prefix), true
+		}
+	}
+	return "", "", false
+}
+
+// mergeErrorFragments joins the per line error strings PowerShell writes
+// for a formatted error into one record.
+func mergeErrorFragments(records []streamRecord) []streamRecord {
+	var merged []streamRecord
+	for _, r := range records {
+		if r.stream == clixmlError && r.err == nil && len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.stream == clixmlError && last.err == nil {
+				last.text += r.text
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	for i := range merged {
+		merged[i].text = strings.TrimSpace(merged[i].text)
+	}
+	return merged
+}
+
+// errorFromRecords returns the error records as a single PSError led by
+// the terminating error, if there was one, or nil when there were none.
+func errorFromRecords(records []streamRecord, exitCode int) *PSError {
+	var errs []*PSError
+	lead := -1
+	for _, r := range records {
+		if r.stream != clixmlError {
+			continue
+		}
+		e := r.err
+		if e == nil {
+			e = &PSError{Message: r.text}
+		}
+		e.ExitCode = exitCode
+		if e.Terminating && lead < 0 {
+			lead = len(errs)
+		}
+		errs = append(errs, e)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if lead < 0 {
+		lead = 0
+	}
+
+	first := errs[lead]
+	for i, e := range errs {
+		if i != lead {
+			first.Additional = append(first.Additional, e)
+		}
+	}
+	return first
+}
+
+type clixmlNode struct {
+	XMLName  xml.Name
+	Name     string      `xml:"N,attr"`
+	Stream   string      `xml:"S,attr"`
+	Text     string      `xml:",chardata"`
+	ToString string      `xml:"ToString"`
+	Types    []string    `xml:"TN>T"`
+	MS       clixmlNodes `xml:"MS"`
+	Props    clixmlNodes `xml:"Props"`
+}
+
+type clixmlNodes struct {
+	Items []clixmlNode `xml:",any"`
+}
+
+func parseCLIXML(doc string) []streamRecord {
+	var objs struct {
+		Items []clixmlNode `xml:",any"`
+	}
+	if err := xml.Unmarshal([]byte(doc), &objs); err != nil {
+		return []streamRecord{{stream: clixmlError, text: doc}}
+	}
+
+	var records []streamRecord
+	for _, item := range objs.Items {
+		stream := strings.ToLower(item.Stream)
+
+		if item.XMLName.Local == "Obj" && (stream == "" || stream == clixmlError) {
+			if props := item.properties(); isErrorRecord(item, props) {
+				e := errorFromProperties(props)
+				records = append(records, streamRecord{stream: clixmlError, text: e.Message, err: e})
+				continue
+			}
+		}
+
+		text := decodeCLIXMLString(item.Text)
+		if item.XMLName.Local == "Obj" {
+			text = item.displayText()
+		}
+		if stream == "" {
+			stream = clixmlError
+		}
+		records = append(records, streamRecord{stream: stream, text: text})
+	}
+	return records
+}
+
+// properties flattens the extended and adapted properties of an object
+// to strings.
+func (n clixmlNode) properties() map[string]string {
+	props := map[string]string{}
+	for _, items := range [][]clixmlNode{n.Props.Items, n.MS.Items} {
+		for _, item := range items {
+			if item.Name == "" {
+				continue
+			}
+			switch item.XMLName.Local {
+			case "Nil":
+				props[item.Name] = ""
+			case "Obj":
+				props[item.Name] = item.displayText()
+				if len(item.Types) > 0 {
+					props[item.Name+".Type"] = strings.TrimPrefix(item.Types[0], "Deserialized.")
+				}
+			default:
+				props[item.Name] = decodeCLIXMLString(item.Text)
+			}
+		}
+	}
+	return props
+}
+
+// displayText is the best human readable form of an object: its
+// Message or StatusDescription property, or else its ToString.
+func (n clixmlNode) displayText() string {
+	props := n.properties()
+	for _, key := range []string{"Message", "StatusDescription", "Activity"} {
+		if props[key] != "" {
+			return props[key]
+		}
+	}
+	return decodeCLIXMLString(n.ToString)
+}
+
+func isErrorRecord(n clixmlNode, props map[string]string) bool {
+	for _, t := range n.Types {
+		if strings.HasSuffix(t, "System.Management.Automation.ErrorRecord") {
+			return true
+		}
+	}
+	_, ok := props["FullyQualifiedErrorId"]
+	return ok
+}
+
+// errorFromProperties builds a PSError from either the object written by
+// Write-PSRemoteError or a serialised ErrorRecord.
+func errorFromProperties(props map[string]string) *PSError {
+	e := &PSError{
+		Message:               props["Message"],
+		FullyQualifiedErrorID: props["FullyQualifiedErrorId"],
+		CategoryInfo: CategoryInfo{
+			Category:   firstOf(props, "Category", "ErrorCategory_Category"),
+			Activity:   firstOf(props, "Activity", "ErrorCategory_Activity"),
+			Reason:     firstOf(props, "Reason", "ErrorCategory_Reason"),
+			TargetName: firstOf(props, "TargetName", "ErrorCategory_TargetName"),
+			TargetType: firstOf(props, "TargetType", "ErrorCategory_TargetType"),
+		},
+		TargetObject:     props["TargetObject"],
+		ScriptStackTrace: firstOf(props, "ScriptStackTrace", "ErrorDetails_ScriptStackTrace"),
+		ExceptionType:    firstOf(props, "ExceptionType", "Exception.Type"),
+		ComputerName:     firstOf(props, "ComputerName", "PSComputerName"),
+		Terminating:      strings.EqualFold(props["Terminating"], "true"),
+	}
+
+	if n, err := strconv.Atoi(e.CategoryInfo.Category); err == nil && n >= 0 && n < len(errorCategories) {
+		e.CategoryInfo.Category = errorCategories[n]
+	}
+
+	if e.Message == "" {
+		e.Message = firstOf(props, "ErrorDetails_Message", "Exception", "ErrorCategory_Message")
+	}
+
+	return e
+}
+
+func firstOf(props map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := props[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+var clixmlEscape = regexp.MustCompile(`_x([0-9A-Fa-f]{4})_`)
+
+// decodeCLIXMLString reverses the _xHHHH_ escaping CLIXML applies to
+// control characters.
+func decodeCLIXMLString(s string) string {
+	return clixmlEscape.ReplaceAllStringFunc(s, func(m string) string {
+		n, _ := strconv.ParseUint(m[2:6], 16, 16)
+		return string(rune(n))
+	})
+}
@@ -0,0 +1,225 @@
+package psremote
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Samples of stderr in the formats PowerShell writes.
+const (
+	// The line Write-PSRemoteError writes for
+	// Get-Item C:\nope -ErrorAction Stop.
+	errorInfoSample = `#< CLIXML <Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS><S N="Message">Cannot find path 'C:\nope' because it does not exist.</S><S N="FullyQualifiedErrorId">PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand</S><S N="Category">ObjectNotFound</S><S N="Activity">Get-Item</S><S N="Reason">ItemNotFoundException</S><S N="TargetName">C:\nope</S><S N="TargetType">String</S><S N="TargetObject">C:\nope</S><S N="ScriptStackTrace">at &lt;ScriptBlock&gt;, &lt;No file&gt;: line 1</S><S N="ExceptionType">System.Management.Automation.ItemNotFoundException</S><Nil N="ComputerName" /><B N="Terminating">true</B></MS></Obj></Objs>`
+
+	// What powershell -EncodedCommand writes for the same error when it
+	// is not caught, one fragment per line of the formatted message.
+	encodedCommandSample = "#< CLIXML\r\n" +
+		`<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><S S="Error">Get-Item : Cannot find path 'C:\nope' because it does not exist._x000D__x000A_</S><S S="Error">At line:1 char:1_x000D__x000A_</S><S S="Error">+ Get-Item C:\nope_x000D__x000A_</S><S S="Error">+ ~~~~~~~~~~~~~~~~_x000D__x000A_</S><S S="Error">    + CategoryInfo          : ObjectNotFound: (C:\nope:String) [Get-Item], ItemNotFoundException_x000D__x000A_</S><S S="Error">    + FullyQualifiedErrorId : PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand_x000D__x000A_</S><S S="Error"> _x000D__x000A_</S></Objs>` + "\r\n"
+
+	// The progress record PowerShell writes while loading modules, and
+	// the other streams as CLIXML strings.
+	streamsSample = "#< CLIXML\r\n" +
+		`<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj S="progress" RefId="0"><TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Preparing modules for first use.</AV><AI>0</AI><Nil /><PI>-1</PI><PC>-1</PC><T>Completed</T><SR>-1</SR><SD> </SD></PR></MS></Obj><S S="warning">The VM is already running._x000D__x000A_</S><S S="verbose">Starting web01._x000D__x000A_</S><S S="debug">state=2_x000D__x000A_</S></Objs>` + "\r\n"
+
+	// An ErrorRecord as serialised by Export-Clixml or remoting, with
+	// the category as a number.
+	errorRecordSample = `#< CLIXML <Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T><T>System.Object</T></TN><ToString>Hyper-V was unable to find a virtual machine with name "web01".</ToString><Props><Obj N="Exception" RefId="1"><TN RefId="1"><T>Microsoft.HyperV.PowerShell.VirtualizationException</T><T>System.Exception</T><T>System.Object</T></TN><ToString>Microsoft.HyperV.PowerShell.VirtualizationException: Hyper-V was unable to find a virtual machine with name "web01".</ToString><Props><S N="Message">Hyper-V was unable to find a virtual machine with name "web01".</S></Props></Obj><S N="TargetObject">web01</S><S N="FullyQualifiedErrorId">InvalidParameter,Microsoft.HyperV.PowerShell.Commands.GetVM</S><Nil N="InvocationInfo" /><I32 N="ErrorCategory_Category">5</I32><S N="ErrorCategory_Activity">Get-VM</S><S N="ErrorCategory_Reason">VirtualizationException</S><S N="ErrorCategory_TargetName">web01</S><S N="ErrorCategory_TargetType">String</S><S N="ErrorCategory_Message">InvalidArgument: (web01:String) [Get-VM], VirtualizationException</S><B N="SerializeExtendedInfo">false</B><S N="ErrorDetails_ScriptStackTrace">at &lt;ScriptBlock&gt;, &lt;No file&gt;: line 1</S><S N="PSComputerName">hyperv01</S></Props></Obj></Objs>`
+)
+
+var getItemError = &PSError{
+	Message:               "Cannot find path 'C:\\nope' because it does not exist.",
+	FullyQualifiedErrorID: "PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand",
+	CategoryInfo: CategoryInfo{
+		Category:   CategoryObjectNotFound,
+		Activity:   "Get-Item",
+		Reason:     "ItemNotFoundException",
+		TargetName: `C:\nope`,
+		TargetType: "String",
+	},
+	TargetObject:     `C:\nope`,
+	ScriptStackTrace: "at <ScriptBlock>, <No file>: line 1",
+	ExceptionType:    "System.Management.Automation.ItemNotFoundException",
+	Terminating:      true,
+}
+
+var getVMError = &PSError{
+	Message:               `Hyper-V was unable to find a virtual machine with name "web01".`,
+	FullyQualifiedErrorID: "InvalidParameter,Microsoft.HyperV.PowerShell.Commands.GetVM",
+	CategoryInfo: CategoryInfo{
+		Category:   CategoryInvalidArgument,
+		Activity:   "Get-VM",
+		Reason:     "VirtualizationException",
+		TargetName: "web01",
+		TargetType: "String",
+	},
+	TargetObject:     "web01",
+	ScriptStackTrace: "at <ScriptBlock>, <No file>: line 1",
+	ExceptionType:    "Microsoft.HyperV.PowerShell.VirtualizationException",
+	ComputerName:     "hyperv01",
+}
+
+func TestParseStderr(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		stderr string
+		want   []streamRecord
+	}{
+		{"empty", "", nil},
+		{"error info", errorInfoSample + "\n", []streamRecord{
+			{stream: clixmlError, text: getItemError.Message, err: getItemError},
+		}},
+		{"serialised error record", errorRecordSample, []streamRecord{
+			{stream: clixmlError, text: getVMError.Message, err: getVMError},
+		}},
+		{"encoded command fragments", encodedCommandSample, []streamRecord{
+			{stream: clixmlError, text: "Get-Item : Cannot find path 'C:\\nope' because it does not exist.\r\nAt line:1 char:1\r\n+ Get-Item C:\\nope\r\n+ ~~~~~~~~~~~~~~~~\r\n" +
+				"    + CategoryInfo          : ObjectNotFound: (C:\\nope:String) [Get-Item], ItemNotFoundException\r\n" +
+				"    + FullyQualifiedErrorId : PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand"},
+		}},
+		{"streams", streamsSample, []streamRecord{
+			{stream: clixmlProgress},
+			{stream: clixmlWarning, text: "The VM is already running."},
+			{stream: clixmlVerbose, text: "Starting web01."},
+			{stream: clixmlDebug, text: "state=2"},
+		}},
+		{"prefixes", "WARNING: low disk\r\nVERBOSE: copying\r\nDEBUG: x=1\r\nPROGRESS: Copy: disk.vhdx (50%)\r\n", []streamRecord{
+			{stream: clixmlWarning, text: "low disk"},
+			{stream: clixmlVerbose, text: "copying"},
+			{stream: clixmlDebug, text: "x=1"},
+			{stream: clixmlProgress, text: "Copy: disk.vhdx (50%)"},
+		}},
+		{"plain text", "The term 'Get-Nothing' is not recognized\r\nas the name of a cmdlet.\r\n", []streamRecord{
+			{stream: clixmlError, text: "The term 'Get-Nothing' is not recognized\nas the name of a cmdlet."},
+		}},
+		{"plain text between records", "WARNING: first\nsomething broke\n" + errorInfoSample + "\nWARNING: last\n", []streamRecord{
+			{stream: clixmlWarning, text: "first"},
+			{stream: clixmlError, text: "something broke"},
+			{stream: clixmlError, text: getItemError.Message, err: getItemError},
+			{stream: clixmlWarning, text: "last"},
+		}},
+		{"malformed", "#< CLIXML <Objs><S S=\"Error\">unterminated</Objs>\n", []streamRecord{
+			{stream: clixmlError, text: `<Objs><S S="Error">unterminated</Objs>`},
+		}},
+		{"truncated", "#< CLIXML\n<Objs Version=\"1.1.0.1\"><S S=\"Error\">cut off", []streamRecord{
+			{stream: clixmlError, text: `<Objs Version="1.1.0.1"><S S="Error">cut off`},
+		}},
+	} {
+		got := parseStderr(c.stderr)
+		if len(got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got %#v\nwant %#v", c.name, got, c.want)
+			for i := range got {
+				if i < len(c.want) && got[i].err != nil && c.want[i].err != nil {
+					t.Logf("%s: error %d: %+v", c.name, i, *got[i].err)
+				}
+			}
+		}
+	}
+}
+
+func TestErrorFromRecords(t *testing.T) {
+	if errorFromRecords(parseStderr("WARNING: only a warning\n"), 0) != nil {
+		t.Error("a warning was reported as an error")
+	}
+
+	// The terminating error leads, whatever its position.
+	records := parseStderr("first\n" + errorInfoSample + "\n")
+	e := errorFromRecords(records, 1)
+	if e.Message != getItemError.Message || !e.Terminating || e.ExitCode != 1 {
+		t.Errorf("lead error %+v", e)
+	}
+	if len(e.Additional) != 1 || e.Additional[0].Message != "first" || e.Additional[0].ExitCode != 1 {
+		t.Errorf("additional errors %+v", e.Additional)
+	}
+}
+
+func TestPSErrorFromOutput(t *testing.T) {
+	fake := NewFakeExecutor(FakeResponse{
+		Stdout: "partial\n",
+		Stderr: "WARNING: disk is almost full\n" + errorRecordSample + "\n",
+		Err:    &ExitError{Code: 1},
+	})
+	ps := &PSRemote{PowerShellPath: "pwsh", Executor: fake}
+
+	result, err := ps.OutputResult("Get-VM web01", nil)
+
+	if !errors.Is(err, ErrRemoteScript) {
+		t.Errorf("error %v does not match ErrRemoteScript", err)
+	}
+	var psErr *PSError
+	if !errors.As(err, &psErr) {
+		t.Fatalf("error %v, want a *PSError", err)
+	}
+	want := *getVMError
+	want.ExitCode = 1
+	if !reflect.DeepEqual(*psErr, want) {
+		t.Errorf("PSError %+v, want %+v", *psErr, want)
+	}
+	if psErr.Category() != CategoryInvalidArgument || psErr.ErrorID() != "InvalidParameter" {
+		t.Errorf("Category() = %s, ErrorID() = %s", psErr.Category(), psErr.ErrorID())
+	}
+	if s := psErr.CategoryInfo.String(); s != "InvalidArgument: (web01:String) [Get-VM], VirtualizationException" {
+		t.Errorf("CategoryInfo %q", s)
+	}
+
+	if result.Stdout != "partial" || !result.HadErrors || result.ExitCode != 1 {
+		t.Errorf("result %+v", result)
+	}
+	if !reflect.DeepEqual(result.Warnings, []string{"disk is almost full"}) {
+		t.Errorf("warnings %q", result.Warnings)
+	}
+}
+
+// TestRemoteExitCode runs remoteScriptBlock with the real PowerShell, when
+// it is installed. Invoke-Command without a target runs the script block
+// locally, through the same wrapping as a remote call.
+func TestRemoteExitCode(t *testing.T) {
+	powershell, err := FindPowerShell()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ps := &PSRemote{PowerShellPath: powershell.Path}
+	for _, c := range []struct {
+		script string
+		stdout string
+		code   int
+	}{
+		{"'done'", "done", 0},
+		{"'partial'; exit 3", "partial", 3},
+		{"try { exit 4 } finally { 'cleanup' }", "cleanup", 4},
+		{"exit 0", "", 0},
+		{"throw 'boom'", "", 1},
+	} {
+		result, err := ps.OutputResult(remoteScriptBlock("", c.script), nil)
+		if result == nil {
+			t.Errorf("%s: no result, error %v", c.script, err)
+			continue
+		}
+		if result.Stdout != c.stdout || result.ExitCode != c.code {
+			t.Errorf("%s: stdout %q, exit code %d, want %q and %d", c.script, result.Stdout, result.ExitCode, c.stdout, c.code)
+		}
+		if (err != nil) != (c.code != 0) {
+			t.Errorf("%s: error %v", c.script, err)
+		}
+	}
+}
+
+// TestRemoteExitCodeFinally checks that the exit code is sent from a
+// finally block, which an exit statement in the script block still runs.
+func TestRemoteExitCodeFinally(t *testing.T) {
+	script := remoteScriptBlock("@psremoteArgs", "exit 3")
+
+	finally := strings.Index(script, "} finally {")
+	sent := strings.Index(script, "PSRemoteExitCode = $global:LASTEXITCODE")
+	if finally < 0 || sent < finally {
+		t.Errorf("the exit code is not sent from a finally block:\n%s", script)
+	}
+	if strings.Index(script, "exit 3") > finally {
+		t.Error("the script block is not inside the try block")
+	}
+}
@@ -0,0 +1,119 @@
+package psremote
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler answers each call with two lines naming the call, and
+// records how many calls run at once.
+func countingHandler(running, peak *int32) func(cmd *Command) FakeResponse {
+	return func(cmd *Command) FakeResponse {
+		n := atomic.AddInt32(running, 1)
+		defer atomic.AddInt32(running, -1)
+		for {
+			p := atomic.LoadInt32(peak)
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		id := "local"
+		if i := strings.Index(cmd.Script, "call-"); i >= 0 {
+			id = strings.Fields(cmd.Script[i:])[0]
+		}
+		id = strings.Trim(id, `'"`)
+		return FakeResponse{Stdout: id + " first\n" + id + " second\n"}
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	var running, peak int32
+	var stdout bytes.Buffer
+	fake := &FakeExecutor{Handler: countingHandler(&running, &peak)}
+	ps := &PSRemote{
+		ComputerName:   "host",
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+		Stdout:         &stdout,
+		Limiter:        NewHostLimiter(2),
+	}
+
+	const calls = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ps.OutputWinRm(fmt.Sprintf("'call-%d'", i), nil)
+			errs <- err
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ps.Output(fmt.Sprintf("'call-local-%d'", i), nil)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(fake.Calls()); n != 2*calls {
+		t.Errorf("%d calls, want %d", n, 2*calls)
+	}
+	if peak > 2 {
+		t.Errorf("%d calls ran at once, limit is 2", peak)
+	}
+
+	// Every line of every call reaches Stdout whole.
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 4*calls {
+		t.Fatalf("%d lines on Stdout, want %d", len(lines), 4*calls)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "call-") || (fields[1] != "first" && fields[1] != "second") {
+			t.Errorf("interleaved line %q", line)
+		}
+	}
+}
+
+func TestConfigurationIsCopiedOnFirstUse(t *testing.T) {
+	fake := NewFakeExecutor()
+	ps := &PSRemote{ComputerName: "first", PowerShellPath: "pwsh", Executor: fake}
+
+	if _, err := ps.OutputWinRm("hostname", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes after the first call have no effect, even while other
+	// calls are running.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ps.OutputWinRm("hostname", nil)
+		}()
+	}
+	ps.ComputerName = "second"
+	ps.Editions = append(ps.Editions, EditionCore)
+	wg.Wait()
+
+	for _, call := range fake.Calls() {
+		if !strings.Contains(call.Script, "'first'") || strings.Contains(call.Script, "'second'") {
+			t.Fatal("a call used the configuration changed after first use")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nimerix/psremote"
+	"github.com/nimerix/psremote/hvremote"
+)
+
+// NewPSRemoteFromProfile returns a PSRemote for the profile called name in
+// the default configuration file, see LoadDefault. An empty name selects
+// the default profile.
+func NewPSRemoteFromProfile(name string) (*psremote.PSRemote, error) {
+	cfg, err := LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.PSRemote(name)
+}
+
+// NewHypervRemoteFromProfile is like NewPSRemoteFromProfile but returns a
+// HypervRemote.
+func NewHypervRemoteFromProfile(name string) (*hvremote.HypervRemote, error) {
+	cfg, err := LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.HypervRemote(name)
+}
+
+// PSRemote returns a PSRemote for the profile called name, see Profile.
+func (c *Config) PSRemote(name string) (*psremote.PSRemote, error) {
+	profile, err := c.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.PSRemote()
+}
+
+// HypervRemote returns a HypervRemote for the profile called name, see
+// Profile.
+func (c *Config) HypervRemote(name string) (*hvremote.HypervRemote, error) {
+	profile, err := c.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.HypervRemote()
+}
+
+// PSRemote returns a PSRemote for the host p describes.
+func (p Profile) PSRemote() (*psremote.PSRemote, error) {
+	transport, err := p.transport()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+	}
+
+	authentication, err := p.authentication()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+	}
+
+	ps := &psremote.PSRemote{
+		ComputerName:   p.Address,
+		UseSSL:         p.UseSSL,
+		Transport:      transport,
+		Port:           p.Port,
+		KeyFilePath:    p.KeyFile,
+		Authentication: authentication,
+		SessionOptions: psremote.SessionOptions{
+			SkipCACheck: p.SkipCACheck,
+			SkipCNCheck: p.SkipCNCheck,
+		},
+	}
+
+	credential := p.Credential
+	fromEnv := credential.UserNameEnv != "" || credential.PasswordEnv != ""
+	switch {
+	case credential.File != "" && fromEnv:
+		return nil, fmt.Errorf("profile %s: credential is both in a file and in environment variables", p.Name)
+	case credential.File != "":
+		ps.Credentials = psremote.FileCredentials{Path: credential.File}
+	case fromEnv:
+		ps.Credentials = psremote.EnvCredentials{
+			UserNameVar: credential.UserNameEnv,
+			PasswordVar: credential.PasswordEnv,
+			UserName:    credential.UserName,
+		}
+	default:
+		ps.UserName = credential.UserName
+	}
+
+	return ps, nil
+}
+
+// HypervRemote returns a HypervRemote for the host p describes, creating
+// virtual machines and disks in VMPath and VHDPath.
+func (p Profile) HypervRemote() (*hvremote.HypervRemote, error) {
+	ps, err := p.PSRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	return &hvremote.HypervRemote{
+		Ps:      ps,
+		VMPath:  p.VMPath,
+		VHDPath: p.VHDPath,
+	}, nil
+}
+
+func (p Profile) transport() (psremote.Transport, error) {
+	switch strings.ToLower(p.Transport) {
+	case "", "powershell":
+		return psremote.TransportPowerShell, nil
+	case "winrm":
+		return psremote.TransportWinRM, nil
+	case "ssh":
+		return psremote.TransportSSH, nil
+	}
+	return 0, fmt.Errorf("unknown transport %q", p.Transport)
+}
+
+func (p Profile) authentication() (psremote.Authentication, error) {
+	if p.Authentication == "" {
+		return "", nil
+	}
+
+	for _, authentication := range []psremote.Authentication{
+		psremote.AuthenticationDefault,
+		psremote.AuthenticationNegotiate,
+		psremote.AuthenticationKerberos,
+		psremote.AuthenticationCredSSP,
+		psremote.AuthenticationBasic,
+	} {
+		if strings.EqualFold(p.Authentication, string(authentication)) {
+			return authentication, nil
+		}
+	}
+	return "", fmt.Errorf("unknown authentication %q", p.Authentication)
+}
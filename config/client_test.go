@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nimerix/psremote"
+)
+
+func TestProfileCredentials(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		credential Credential
+		userName   string
+		provider   psremote.CredentialProvider
+	}{
+		{"user name only", Credential{UserName: "ci"}, "ci", nil},
+		{"file", Credential{File: "/etc/psremote/lab"}, "",
+			psremote.FileCredentials{Path: "/etc/psremote/lab"}},
+		{"environment", Credential{UserName: "admin", PasswordEnv: "LAB_PASSWORD"}, "",
+			psremote.EnvCredentials{PasswordVar: "LAB_PASSWORD", UserName: "admin"}},
+		{"environment user name", Credential{UserNameEnv: "LAB_USER", PasswordEnv: "LAB_PASSWORD"}, "",
+			psremote.EnvCredentials{UserNameVar: "LAB_USER", PasswordVar: "LAB_PASSWORD"}},
+	} {
+		ps, err := Profile{Name: "lab", Address: "lab", Credential: c.credential}.PSRemote()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if ps.UserName != c.userName || !reflect.DeepEqual(ps.Credentials, c.provider) {
+			t.Errorf("%s: user name %q, credentials %#v, want %q and %#v", c.name, ps.UserName, ps.Credentials, c.userName, c.provider)
+		}
+	}
+
+	both := Credential{File: "/etc/psremote/lab", UserNameEnv: "LAB_USER"}
+	if _, err := (Profile{Name: "lab", Credential: both}).PSRemote(); err == nil {
+		t.Error("credential in a file and in the environment was accepted")
+	}
+}
+
+func TestProfilePSRemote(t *testing.T) {
+	profile, err := sampleConfig.Profile("lab-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hvc, err := profile.HypervRemote()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := hvc.Ps
+	if ps.ComputerName != "lab-01.example.com" || ps.Port != 5986 || !ps.UseSSL ||
+		ps.Transport != psremote.TransportWinRM || ps.Authentication != psremote.AuthenticationNegotiate ||
+		!ps.SessionOptions.SkipCACheck || ps.SessionOptions.SkipCNCheck {
+		t.Errorf("PSRemote %+v", ps)
+	}
+	if hvc.VMPath != `D:\VMs` || hvc.VHDPath != "" {
+		t.Errorf("paths %q and %q", hvc.VMPath, hvc.VHDPath)
+	}
+
+	for _, bad := range []Profile{
+		{Name: "lab", Transport: "telnet"},
+		{Name: "lab", Authentication: "digest"},
+	} {
+		if _, err := bad.PSRemote(); err == nil {
+			t.Errorf("profile %+v was accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package config loads named host profiles from a YAML, JSON or TOML
+// file, with environment variables overriding the file, and returns a
+// PSRemote or HypervRemote for them, such as with
+// NewHypervRemoteFromProfile. It is kept apart from psremote so that only
+// its users depend on the file parsers.
+//
+// A YAML file looks like:
+//
+//	default: lab-01
+//	profiles:
+//	  lab-01:
+//	    address: lab-01.example.com
+//	    transport: winrm
+//	    use_ssl: true
+//	    skip_ca_check: true
+//	    vm_path: D:\VMs
+//	    credential:
+//	      user_name_env: LAB_USER
+//	      password_env: LAB_PASSWORD
+//
+// Passwords cannot be written in the file, only where to find them.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrProfileNotFound is returned when a profile is neither in the file
+// nor set by environment variables.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Config is a set of named host profiles.
+type Config struct {
+	// Default names the profile used when none is given. PSREMOTE_PROFILE
+	// overrides it.
+	Default  string             `yaml:"default" json:"default" toml:"default"`
+	Profiles map[string]Profile `yaml:"profiles" json:"profiles" toml:"profiles"`
+}
+
+// Profile describes how to reach one host.
+//
+// Every field can be overridden by an environment variable named
+// PSREMOTE_PROFILE_<NAME>_<FIELD>, where NAME is the profile name in upper
+// case with anything but letters and digits replaced by underscores, such
+// as PSREMOTE_PROFILE_LAB_01_ADDRESS. FIELD is the field's name in the
+// file in upper case, and for the fields of credential the name without
+// the credential level, such as PASSWORD_ENV, except that credential's
+// file is CREDENTIAL_FILE. Two profiles whose names give the same NAME,
+// such as lab-01 and lab_01, are an error.
+type Profile struct {
+	// Name is the name the profile was looked up by.
+	Name string `yaml:"-" json:"-" toml:"-"`
+	// Address is the host name or IP address of the host. Empty uses
+	// the profile name.
+	Address string `yaml:"address" json:"address" toml:"address"`
+	// Port is the port of the host. Zero selects the transport's default.
+	Port int `yaml:"port" json:"port" toml:"port"`
+	// Transport is "powershell", the default, "winrm" or "ssh", see
+	// psremote.Transport.
+	Transport string `yaml:"transport" json:"transport" toml:"transport"`
+	// Authentication is the WinRM authentication mechanism, such as
+	// "Negotiate" or "Kerberos". Empty leaves PowerShell's default.
+	Authentication string `yaml:"authentication" json:"authentication" toml:"authentication"`
+	// UseSSL connects over HTTPS. SkipCACheck and SkipCNCheck accept
+	// certificates that would otherwise be rejected, see
+	// psremote.SessionOptions.
+	UseSSL      bool `yaml:"use_ssl" json:"use_ssl" toml:"use_ssl"`
+	SkipCACheck bool `yaml:"skip_ca_check" json:"skip_ca_check" toml:"skip_ca_check"`
+	SkipCNCheck bool `yaml:"skip_cn_check" json:"skip_cn_check" toml:"skip_cn_check"`
+	// KeyFile is the SSH private key used with the ssh transport.
+	KeyFile string `yaml:"key_file" json:"key_file" toml:"key_file"`
+	// VMPath and VHDPath are the directories on the host where virtual
+	// machines and disks are created when a call does not say.
+	VMPath     string     `yaml:"vm_path" json:"vm_path" toml:"vm_path"`
+	VHDPath    string     `yaml:"vhd_path" json:"vhd_path" toml:"vhd_path"`
+	Credential Credential `yaml:"credential" json:"credential" toml:"credential"`
+}
+
+// Credential says where the credential for a host is kept. At most one of
+// File and the environment variables may be set. With neither, only
+// UserName is used, such as for SSH keys or Kerberos.
+type Credential struct {
+	// UserName is the user name, when it is not read from UserNameEnv or
+	// File.
+	UserName string `yaml:"user_name" json:"user_name" toml:"user_name"`
+	// UserNameEnv and PasswordEnv name the environment variables holding
+	// the user name and password.
+	UserNameEnv string `yaml:"user_name_env" json:"user_name_env" toml:"user_name_env"`
+	PasswordEnv string `yaml:"password_env" json:"password_env" toml:"password_env"`
+	// File holds the user name on its first line and the password on its
+	// second, see psremote.FileCredentials.
+	File string `yaml:"file" json:"file" toml:"file"`
+}
+
+// Format is the format of a configuration file.
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// FormatOf returns the format of the file at path, from its extension.
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".json":
+		return FormatJSON, nil
+	case ".toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("config file %s: unknown format", path)
+}
+
+// Parse parses a configuration file in the given format. Unknown fields
+// are an error, so that misspelt settings are not silently ignored.
+func Parse(data []byte, format Format) (*Config, error) {
+	config := new(Config)
+
+	switch format {
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file is an empty configuration.
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		meta, err := toml.Decode(string(data), config)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown field %s", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]Profile)
+	}
+	for _, name := range config.Names() {
+		if err := config.checkPrefix(name); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// Load reads the configuration file at path, in the format given by its
+// extension.
+func Load(path string) (*Config, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// DefaultPath returns the path of the default configuration file:
+// PSREMOTE_CONFIG if it is set, or else the first of config.yaml,
+// config.yml, config.json and config.toml found in the psremote directory
+// of os.UserConfigDir. It returns "" when there is no such file.
+func DefaultPath() string {
+	if path := os.Getenv("PSREMOTE_CONFIG"); path != "" {
+		return path
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	for _, name := range []string{"config.yaml", "config.yml", "config.json", "config.toml"} {
+		path := filepath.Join(dir, "psremote", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadDefault loads the file at DefaultPath. Without one it returns an
+// empty configuration, whose profiles can still be set by environment
+// variables.
+func LoadDefault() (*Config, error) {
+	path := DefaultPath()
+	if path == "" {
+		return &Config{Profiles: make(map[string]Profile)}, nil
+	}
+	return Load(path)
+}
+
+// Names returns the names of the profiles in the file, sorted.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the profile called name with the environment overrides
+// applied. An empty name selects PSREMOTE_PROFILE, or else Default.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = os.Getenv("PSREMOTE_PROFILE")
+	}
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return Profile{}, fmt.Errorf("%w: no profile given and no default set", ErrProfileNotFound)
+	}
+
+	if err := c.checkPrefix(name); err != nil {
+		return Profile{}, err
+	}
+
+	profile, ok := c.Profiles[name]
+	overridden, err := profile.applyEnv(envPrefix(name), os.LookupEnv)
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %s: %v", name, err)
+	}
+	if !ok && !overridden {
+		return Profile{}, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+
+	profile.Name = name
+	if profile.Address == "" {
+		profile.Address = name
+	}
+	return profile, nil
+}
+
+// checkPrefix returns an error if another profile is overridden by the
+// same environment variables as the profile called name.
+func (c *Config) checkPrefix(name string) error {
+	prefix := envPrefix(name)
+	for _, other := range c.Names() {
+		if other != name && envPrefix(other) == prefix {
+			first, second := other, name
+			if second < first {
+				first, second = second, first
+			}
+			return fmt.Errorf("profiles %s and %s are both overridden by %s variables", first, second, prefix+"*")
+		}
+	}
+	return nil
+}
+
+// envFields are the profile fields that environment variables override,
+// by the suffix of the variable.
+var envFields = []struct {
+	suffix string
+	field  func(p *Profile) interface{}
+}{
+	{"ADDRESS", func(p *Profile) interface{} { return &p.Address }},
+	{"PORT", func(p *Profile) interface{} { return &p.Port }},
+	{"TRANSPORT", func(p *Profile) interface{} { return &p.Transport }},
+	{"AUTHENTICATION", func(p *Profile) interface{} { return &p.Authentication }},
+	{"USE_SSL", func(p *Profile) interface{} { return &p.UseSSL }},
+	{"SKIP_CA_CHECK", func(p *Profile) interface{} { return &p.SkipCACheck }},
+	{"SKIP_CN_CHECK", func(p *Profile) interface{} { return &p.SkipCNCheck }},
+	{"KEY_FILE", func(p *Profile) interface{} { return &p.KeyFile }},
+	{"VM_PATH", func(p *Profile) interface{} { return &p.VMPath }},
+	{"VHD_PATH", func(p *Profile) interface{} { return &p.VHDPath }},
+	{"USER_NAME", func(p *Profile) interface{} { return &p.Credential.UserName }},
+	{"USER_NAME_ENV", func(p *Profile) interface{} { return &p.Credential.UserNameEnv }},
+	{"PASSWORD_ENV", func(p *Profile) interface{} { return &p.Credential.PasswordEnv }},
+	{"CREDENTIAL_FILE", func(p *Profile) interface{} { return &p.Credential.File }},
+}
+
+// applyEnv overrides the fields of p that have a variable set, and
+// reports whether any had.
+func (p *Profile) applyEnv(prefix string, lookup func(string) (string, bool)) (bool, error) {
+	overridden := false
+
+	for _, f := range envFields {
+		name := prefix + f.suffix
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		overridden = true
+
+		switch field := f.field(p).(type) {
+		case *string:
+			*field = value
+		case *int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return false, fmt.Errorf("%s: %v", name, err)
+			}
+			*field = n
+		case *bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("%s: %v", name, err)
+			}
+			*field = b
+		}
+	}
+
+	return overridden, nil
+}
+
+// envPrefix returns the prefix of the variables overriding the profile
+// called name.
+func envPrefix(name string) string {
+	return "PSREMOTE_PROFILE_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name) + "_"
+}
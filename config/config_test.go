@@ -0,0 +1,276 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The same configuration in each format.
+const (
+	yamlSample = `default: lab-01
+profiles:
+  lab-01:
+    address: lab-01.example.com
+    port: 5986
+    transport: winrm
+    authentication: negotiate
+    use_ssl: true
+    skip_ca_check: true
+    vm_path: D:\VMs
+    credential:
+      user_name_env: LAB_USER
+      password_env: LAB_PASSWORD
+  build:
+    transport: ssh
+    key_file: /home/ci/.ssh/id_ed25519
+    credential:
+      user_name: ci
+`
+
+	jsonSample = `{
+  "default": "lab-01",
+  "profiles": {
+    "lab-01": {
+      "address": "lab-01.example.com",
+      "port": 5986,
+      "transport": "winrm",
+      "authentication": "negotiate",
+      "use_ssl": true,
+      "skip_ca_check": true,
+      "vm_path": "D:\\VMs",
+      "credential": {"user_name_env": "LAB_USER", "password_env": "LAB_PASSWORD"}
+    },
+    "build": {
+      "transport": "ssh",
+      "key_file": "/home/ci/.ssh/id_ed25519",
+      "credential": {"user_name": "ci"}
+    }
+  }
+}
+`
+
+	tomlSample = `default = "lab-01"
+
+[profiles.lab-01]
+address = "lab-01.example.com"
+port = 5986
+transport = "winrm"
+authentication = "negotiate"
+use_ssl = true
+skip_ca_check = true
+vm_path = 'D:\VMs'
+
+[profiles.lab-01.credential]
+user_name_env = "LAB_USER"
+password_env = "LAB_PASSWORD"
+
+[profiles.build]
+transport = "ssh"
+key_file = "/home/ci/.ssh/id_ed25519"
+
+[profiles.build.credential]
+user_name = "ci"
+`
+)
+
+var sampleConfig = &Config{
+	Default: "lab-01",
+	Profiles: map[string]Profile{
+		"lab-01": {
+			Address:        "lab-01.example.com",
+			Port:           5986,
+			Transport:      "winrm",
+			Authentication: "negotiate",
+			UseSSL:         true,
+			SkipCACheck:    true,
+			VMPath:         `D:\VMs`,
+			Credential:     Credential{UserNameEnv: "LAB_USER", PasswordEnv: "LAB_PASSWORD"},
+		},
+		"build": {
+			Transport:  "ssh",
+			KeyFile:    "/home/ci/.ssh/id_ed25519",
+			Credential: Credential{UserName: "ci"},
+		},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for format, data := range map[Format]string{
+		FormatYAML: yamlSample,
+		FormatJSON: jsonSample,
+		FormatTOML: tomlSample,
+	} {
+		config, err := Parse([]byte(data), format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(config, sampleConfig) {
+			t.Errorf("%s: parsed %+v, want %+v", format, config, sampleConfig)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	config, err := Parse(nil, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Profiles == nil || len(config.Profiles) != 0 {
+		t.Errorf("profiles %v", config.Profiles)
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	for format, data := range map[Format]string{
+		FormatYAML: "profiles:\n  lab:\n    adress: lab.example.com\n",
+		FormatJSON: `{"profiles": {"lab": {"adress": "lab.example.com"}}}`,
+		FormatTOML: "[profiles.lab]\nadress = \"lab.example.com\"\n",
+	} {
+		if _, err := Parse([]byte(data), format); err == nil || !strings.Contains(err.Error(), "adress") {
+			t.Errorf("%s: error %v, want one naming adress", format, err)
+		}
+	}
+
+	if _, err := Parse([]byte(yamlSample), "ini"); err == nil {
+		t.Error("unknown format parsed")
+	}
+}
+
+func TestParseEnvPrefixCollision(t *testing.T) {
+	data := "profiles:\n  lab-01:\n    address: a\n  lab_01:\n    address: b\n"
+	_, err := Parse([]byte(data), FormatYAML)
+	if err == nil || !strings.Contains(err.Error(), "lab-01 and lab_01") {
+		t.Errorf("error %v, want the colliding profiles named", err)
+	}
+
+	// A profile set only by environment variables cannot take over the
+	// variables of one in the file either.
+	config := &Config{Profiles: map[string]Profile{"lab-01": {}}}
+	t.Setenv("PSREMOTE_PROFILE_LAB_01_ADDRESS", "lab.example.com")
+	if _, err := config.Profile("lab.01"); err == nil {
+		t.Error("lab.01 was read from the variables of lab-01")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"config.yml":  yamlSample,
+		"config.json": jsonSample,
+		"config.toml": tomlSample,
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(config, sampleConfig) {
+			t.Errorf("%s: loaded %+v", name, config)
+		}
+	}
+
+	if _, err := Load(filepath.Join(dir, "config.ini")); err == nil {
+		t.Error("config.ini loaded")
+	}
+}
+
+func TestProfileEnvOverrides(t *testing.T) {
+	t.Setenv("PSREMOTE_PROFILE", "")
+	for suffix, value := range map[string]string{
+		"ADDRESS":         "10.0.0.5",
+		"PORT":            "5985",
+		"USE_SSL":         "false",
+		"SKIP_CN_CHECK":   "true",
+		"VHD_PATH":        `E:\VHDs`,
+		"USER_NAME":       "admin",
+		"PASSWORD_ENV":    "OTHER_PASSWORD",
+		"CREDENTIAL_FILE": "/etc/psremote/lab",
+	} {
+		t.Setenv("PSREMOTE_PROFILE_LAB_01_"+suffix, value)
+	}
+
+	profile, err := sampleConfig.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sampleConfig.Profiles["lab-01"]
+	want.Name = "lab-01"
+	want.Address = "10.0.0.5"
+	want.Port = 5985
+	want.UseSSL = false
+	want.SkipCNCheck = true
+	want.VHDPath = `E:\VHDs`
+	want.Credential = Credential{
+		UserName:    "admin",
+		UserNameEnv: "LAB_USER",
+		PasswordEnv: "OTHER_PASSWORD",
+		File:        "/etc/psremote/lab",
+	}
+	if !reflect.DeepEqual(profile, want) {
+		t.Errorf("profile %+v, want %+v", profile, want)
+	}
+
+	// The file's profile is left alone.
+	if sampleConfig.Profiles["lab-01"].Address != "lab-01.example.com" {
+		t.Error("the override changed the configuration")
+	}
+}
+
+func TestProfileSelection(t *testing.T) {
+	t.Setenv("PSREMOTE_PROFILE", "build")
+	profile, err := sampleConfig.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without an address the profile name is used.
+	if profile.Name != "build" || profile.Address != "build" {
+		t.Errorf("profile %+v", profile)
+	}
+
+	t.Setenv("PSREMOTE_PROFILE", "")
+	if _, err := (&Config{}).Profile(""); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("error %v without a default, want ErrProfileNotFound", err)
+	}
+	if _, err := sampleConfig.Profile("missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("error %v, want ErrProfileNotFound", err)
+	}
+
+	// A profile can be set by environment variables alone.
+	t.Setenv("PSREMOTE_PROFILE_ADHOC_HOST_ADDRESS", "192.0.2.7")
+	profile, err = sampleConfig.Profile("adhoc.host")
+	if err != nil || profile.Address != "192.0.2.7" {
+		t.Errorf("profile %+v, error %v", profile, err)
+	}
+}
+
+func TestProfileEnvInvalid(t *testing.T) {
+	for suffix, value := range map[string]string{"PORT": "https", "USE_SSL": "maybe"} {
+		t.Run(suffix, func(t *testing.T) {
+			t.Setenv("PSREMOTE_PROFILE_LAB_01_"+suffix, value)
+			if _, err := sampleConfig.Profile("lab-01"); err == nil || !strings.Contains(err.Error(), "PSREMOTE_PROFILE_LAB_01_"+suffix) {
+				t.Errorf("error %v, want one naming the variable", err)
+			}
+		})
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	for name, want := range map[string]string{
+		"lab-01":  "PSREMOTE_PROFILE_LAB_01_",
+		"lab_01":  "PSREMOTE_PROFILE_LAB_01_",
+		"Hyper.V": "PSREMOTE_PROFILE_HYPER_V_",
+		"büro":    "PSREMOTE_PROFILE_B_RO_",
+		"build":   "PSREMOTE_PROFILE_BUILD_",
+	} {
+		if got := envPrefix(name); got != want {
+			t.Errorf("envPrefix(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
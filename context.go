@@ -0,0 +1,31 @@
+package psremote
+
+import "context"
+
+// CanceledError is returned when a call is stopped because its context
+// was cancelled or its deadline passed. It unwraps to the context error,
+// and matches ErrTimeout when the deadline passed.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	if e.Timeout() {
+		return "PowerShell timed out: " + e.Err.Error()
+	}
+	return "PowerShell cancelled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CanceledError) Is(target error) bool {
+	return target == ErrTimeout && e.Timeout()
+}
+
+// Timeout reports whether the call ran out of time rather than being
+// cancelled.
+func (e *CanceledError) Timeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
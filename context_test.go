@@ -0,0 +1,57 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingExecutor returns only once ctx is done.
+type blockingExecutor struct {
+	started chan struct{}
+}
+
+func (e blockingExecutor) Run(ctx context.Context, cmd *Command) error {
+	close(e.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCanceledError(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+		timeout bool
+	}{
+		{"cancelled", func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, context.Canceled, false},
+		{"deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}, context.DeadlineExceeded, true},
+	} {
+		executor := blockingExecutor{started: make(chan struct{})}
+		ps := &PSRemote{PowerShellPath: "pwsh", Executor: executor}
+
+		ctx, cancel := c.ctx()
+		go func() {
+			<-executor.started
+			if !c.timeout {
+				cancel()
+			}
+		}()
+		_, err := ps.OutputContext(ctx, "Start-Sleep 60", nil)
+		cancel()
+
+		var canceled *CanceledError
+		if !errors.As(err, &canceled) {
+			t.Fatalf("%s: error %v, want a *CanceledError", c.name, err)
+		}
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: error does not unwrap to %v", c.name, c.want)
+		}
+		if canceled.Timeout() != c.timeout || errors.Is(err, ErrTimeout) != c.timeout {
+			t.Errorf("%s: Timeout() = %v, want %v", c.name, canceled.Timeout(), c.timeout)
+		}
+	}
+}
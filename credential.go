@@ -0,0 +1,151 @@
+package psremote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Credential is a user name and password for ComputerName.
+type Credential struct {
+	UserName string
+	Password string
+}
+
+// A CredentialProvider supplies the credential for each remote call. The
+// credential is handed to PowerShell on stdin and is never written to the
+// generated script.
+type CredentialProvider interface {
+	Credential(ctx context.Context) (Credential, error)
+}
+
+// StaticCredentials always returns the same user name and password.
+type StaticCredentials Credential
+
+func (c StaticCredentials) Credential(ctx context.Context) (Credential, error) {
+	return Credential(c), nil
+}
+
+// EnvCredentials reads the user name and password from environment
+// variables on every call. Empty names select PSREMOTE_USERNAME and
+// PSREMOTE_PASSWORD. UserName, when set, is used instead of the user name
+// variable.
+type EnvCredentials struct {
+	UserNameVar string
+	PasswordVar string
+	UserName    string
+}
+
+func (c EnvCredentials) Credential(ctx context.Context) (Credential, error) {
+	userNameVar, passwordVar := c.UserNameVar, c.PasswordVar
+	if userNameVar == "" {
+		userNameVar = "PSREMOTE_USERNAME"
+	}
+	if passwordVar == "" {
+		passwordVar = "PSREMOTE_PASSWORD"
+	}
+
+	userName, ok := c.UserName, c.UserName != ""
+	if !ok {
+		userName, ok = os.LookupEnv(userNameVar)
+	}
+	if !ok {
+		return Credential{}, fmt.Errorf("credential variable %s is not set", userNameVar)
+	}
+
+	return Credential{UserName: userName, Password: os.Getenv(passwordVar)}, nil
+}
+
+// FileCredentials reads the user name from the first line of Path and the
+// password from the second. The file is read on every call so that it can
+// be rotated without restarting.
+type FileCredentials struct {
+	Path string
+}
+
+func (c FileCredentials) Credential(ctx context.Context) (Credential, error) {
+	data, err := ioutil.ReadFile(c.Path)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	lines := strings.SplitN(strings.Replace(string(data), "\r\n", "\n", -1), "\n", 3)
+	if lines[0] == "" {
+		return Credential{}, fmt.Errorf("credential file %s has no user name", c.Path)
+	}
+
+	credential := Credential{UserName: lines[0]}
+	if len(lines) > 1 {
+		credential.Password = lines[1]
+	}
+
+	return credential, nil
+}
+
+// credential returns the credential from ps.Credentials, or UserName and
+// Password when no provider is set. The password is registered as a
+// secret so that it is never logged.
+func (ps *PSRemote) credential(ctx context.Context) (Credential, error) {
+	if ps.Credentials == nil {
+		return Credential{UserName: ps.UserName, Password: ps.Password}, nil
+	}
+
+	credential, err := ps.Credentials.Credential(ctx)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	ps.AddSecret(credential.Password)
+	return credential, nil
+}
+
+// credentialScript defines Read-PSRemoteCredential, which reads the line
+// written by credentialInput from stdin and returns a PSCredential, or
+// $null when none was given. It relies on paramPreamble.
+const credentialScript = `function Read-PSRemoteCredential {
+	$line = [Console]::In.ReadLine()
+	if (!$line) {
+		return $null
+	}
+	$credential = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($line)) | ConvertFrom-Json
+	$password = ConvertTo-SecureString (ConvertFrom-PSRemoteParam $credential.password) -AsPlainText -Force
+	New-Object System.Management.Automation.PSCredential ((ConvertFrom-PSRemoteParam $credential.userName), $password)
+}
+`
+
+// credentialInput encodes credential as the stdin line read by
+// Read-PSRemoteCredential. A credential without both a user name and a
+// password is left out, and the current user's is used instead.
+func credentialInput(credential Credential) (string, error) {
+	if credential.UserName == "" || credential.Password == "" {
+		return "\n", nil
+	}
+
+	line, err := serializeParams(map[string]interface{}{
+		"userName": credential.UserName,
+		"password": credential.Password,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return line + "\n", nil
+}
+
+// remoteCredentialInput returns the stdin line read by
+// Read-PSRemoteCredential for ComputerName. SSH authenticates with keys,
+// so no credential is sent with TransportSSH.
+func (ps *PSRemote) remoteCredentialInput(ctx context.Context) (string, error) {
+	if ps.Transport == TransportSSH {
+		return "\n", nil
+	}
+
+	credential, err := ps.credential(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return credentialInput(credential)
+}
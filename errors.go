@@ -0,0 +1,121 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Classes of failure. Errors returned by PSRemote match one of these with
+// errors.Is when the class is known.
+var (
+	// ErrPowerShellNotFound means no PowerShell could be found to run the
+	// script.
+	ErrPowerShellNotFound = errors.New("PowerShell not found")
+	// ErrConnection means the remote host could not be reached.
+	ErrConnection = errors.New("cannot connect to remote host")
+	// ErrAuthentication means the remote host rejected the credential.
+	ErrAuthentication = errors.New("authentication to remote host failed")
+	// ErrRemoteScript means the script ran but failed. The error is a
+	// *ScriptError.
+	ErrRemoteScript = errors.New("PowerShell script failed")
+	// ErrTimeout means the context deadline passed before the script
+	// finished. The error is a *CanceledError.
+	ErrTimeout = errors.New("PowerShell timed out")
+	// ErrModuleNotLoaded means a PowerShell module the caller needs is
+	// not available.
+	ErrModuleNotLoaded = errors.New("PowerShell module is not loaded")
+	// ErrUnsupportedOption means the options ask for something the
+	// transport cannot do. Nothing was run.
+	ErrUnsupportedOption = errors.New("unsupported option")
+	// ErrInvalidJSON means the output of a script could not be decoded.
+	// The error also unwraps to the encoding/json error.
+	ErrInvalidJSON = errors.New("PowerShell returned invalid JSON")
+)
+
+// ScriptError is returned when a script runs but fails, either with an
+// error record or a non-zero exit code. It unwraps to the *PSError
+// describing the failure.
+type ScriptError struct {
+	// Script names the script that failed, such as GetVirtualMachineId,
+	// when the call was made with a context from WithScriptName. It is
+	// empty otherwise.
+	Script   string
+	ExitCode int
+	Stderr   string
+	Err      *PSError
+}
+
+func (e *ScriptError) Error() string {
+	if e.Script == "" {
+		return e.Err.Error()
+	}
+	return e.Script + ": " + e.Err.Error()
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ScriptError) Is(target error) bool {
+	return target == ErrRemoteScript
+}
+
+// ConnectionError is returned when ComputerName cannot be reached, or
+// rejects the credential when Authentication is set.
+type ConnectionError struct {
+	ComputerName   string
+	Authentication bool
+	Err            error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.Authentication {
+		return "authentication to " + e.ComputerName + " failed: " + e.Err.Error()
+	}
+	return "cannot connect to " + e.ComputerName + ": " + e.Err.Error()
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConnectionError) Is(target error) bool {
+	if e.Authentication {
+		return target == ErrAuthentication
+	}
+	return target == ErrConnection
+}
+
+// scriptError classifies a failed script. Failures to open the remote
+// session become a *ConnectionError, the rest a *ScriptError.
+func (ps *PSRemote) scriptError(ctx context.Context, psErr *PSError, stderr string) error {
+	id := psErr.FullyQualifiedErrorID
+	if strings.Contains(id, "PSSessionStateBroken") || strings.Contains(id, "PSSessionOpenFailed") {
+		return &ConnectionError{
+			ComputerName:   ps.ComputerName,
+			Authentication: strings.Contains(id, "AccessDenied") || strings.Contains(id, "LogonFailure"),
+			Err:            psErr,
+		}
+	}
+
+	return &ScriptError{
+		Script:   scriptName(ctx),
+		ExitCode: psErr.ExitCode,
+		Stderr:   stderr,
+		Err:      psErr,
+	}
+}
+
+type scriptNameKey struct{}
+
+// WithScriptName names the script run with the returned context, so that
+// its ScriptError says which script failed.
+func WithScriptName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, scriptNameKey{}, name)
+}
+
+func scriptName(ctx context.Context) string {
+	name, _ := ctx.Value(scriptNameKey{}).(string)
+	return name
+}
@@ -0,0 +1,51 @@
+package psremote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestScriptErrorName(t *testing.T) {
+	fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		return FakeResponse{Err: &ExitError{Code: 3}}
+	}}
+	ps := &PSRemote{PowerShellPath: "pwsh", Executor: fake}
+
+	for _, c := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		// Unnamed calls say nothing rather than guess.
+		{context.Background(), ""},
+		{WithScriptName(context.Background(), "GetVirtualMachineId"), "GetVirtualMachineId"},
+	} {
+		_, err := ps.OutputResultContext(c.ctx, "exit 3", nil)
+
+		var scriptErr *ScriptError
+		if !errors.As(err, &scriptErr) {
+			t.Fatalf("error %v, want a *ScriptError", err)
+		}
+		if scriptErr.Script != c.want {
+			t.Errorf("Script %q, want %q", scriptErr.Script, c.want)
+		}
+		if scriptErr.ExitCode != 3 {
+			t.Errorf("ExitCode %d, want 3", scriptErr.ExitCode)
+		}
+	}
+}
+
+func TestInvalidJSON(t *testing.T) {
+	ps := &PSRemote{PowerShellPath: "pwsh", Executor: NewFakeExecutor(FakeResponse{Stdout: "{\"Name\": \n"})}
+
+	var out map[string]interface{}
+	err := ps.OutputJSON("Get-VM", nil, &out)
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("error %v, want ErrInvalidJSON", err)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("error %v does not unwrap to the *json.SyntaxError", err)
+	}
+}
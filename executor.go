@@ -0,0 +1,95 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// Command describes a single PowerShell invocation built by PSRemote.
+type Command struct {
+	// Path and Args are the program and arguments to start.
+	Path string
+	Args []string
+	// Script is the full generated script, including the parameter
+	// preamble, exactly as it is handed to PowerShell.
+	Script string
+	// Params are the parameters passed alongside the script.
+	Params map[string]interface{}
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Executor runs the commands generated by PSRemote. The default is
+// LocalExecutor, which starts a local PowerShell process.
+//
+// Run must return once ctx is done, stopping the command if it is still
+// running.
+type Executor interface {
+	Run(ctx context.Context, cmd *Command) error
+}
+
+// LocalExecutor runs commands as local processes. On cancellation the
+// whole process tree is killed, so that PowerShell cannot leave remoting
+// or child processes behind.
+type LocalExecutor struct{}
+
+func (LocalExecutor) Run(ctx context.Context, cmd *Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	command := exec.Command(cmd.Path, cmd.Args...)
+
+	command.Stdin = cmd.Stdin
+	command.Stdout = cmd.Stdout
+	command.Stderr = cmd.Stderr
+
+	setProcessGroup(command)
+
+	if err := command.Start(); err != nil {
+		return startError(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		killProcessTree(command.Process)
+		<-done
+		return ctx.Err()
+	}
+}
+
+// startError classifies a failure to start PowerShell.
+func startError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrPowerShellNotFound, err)
+	}
+	return err
+}
+
+// ExitError reports a command that ran but exited with a non-zero code.
+// Executors other than LocalExecutor return it so that PSRemote can tell a
+// failed script from a failure to run it.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
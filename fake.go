@@ -0,0 +1,187 @@
+package psremote
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// FakeResponse is the canned result of a FakeExecutor call.
+type FakeResponse struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// FakeExecutor is an Executor that never starts a process. It records
+// every command it is given and answers from Handler, if set, or else
+// from the queued Responses in order. Once the queue is exhausted calls
+// succeed with no output.
+//
+// Stdin is recorded with the password of any credential redacted. Handler
+// may read stdin as it arrives, as a session host does, and only what it
+// reads is recorded. Without Handler stdin is read in full.
+type FakeExecutor struct {
+	Handler   func(cmd *Command) FakeResponse
+	Responses []FakeResponse
+
+	mu    sync.Mutex
+	calls []Command
+	stdin []*string
+}
+
+// NewFakeExecutor returns a FakeExecutor that serves the given responses.
+func NewFakeExecutor(responses ...FakeResponse) *FakeExecutor {
+	return &FakeExecutor{Responses: responses}
+}
+
+// Push queues a response for a later call.
+func (f *FakeExecutor) Push(stdout, stderr string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Responses = append(f.Responses, FakeResponse{Stdout: stdout, Stderr: stderr, Err: err})
+}
+
+func (f *FakeExecutor) Run(ctx context.Context, cmd *Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Stdin is kept as it is read, so that Handler can serve a command
+	// that reads it as it goes, such as a session host.
+	var stdin bytes.Buffer
+	if cmd.Stdin != nil {
+		run := *cmd
+		run.Stdin = io.TeeReader(cmd.Stdin, &stdin)
+		cmd = &run
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, copyCommand(cmd))
+	recorded := new(string)
+	f.stdin = append(f.stdin, recorded)
+
+	var resp FakeResponse
+	handler := f.Handler
+	if handler == nil && len(f.Responses) > 0 {
+		resp = f.Responses[0]
+		f.Responses = f.Responses[1:]
+	}
+	f.mu.Unlock()
+
+	if handler != nil {
+		resp = handler(cmd)
+	} else if cmd.Stdin != nil {
+		if _, err := io.Copy(ioutil.Discard, cmd.Stdin); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	*recorded = redactCredential(stdin.String())
+	f.mu.Unlock()
+
+	if err := writeString(cmd.Stdout, resp.Stdout); err != nil {
+		return err
+	}
+	if err := writeString(cmd.Stderr, resp.Stderr); err != nil {
+		return err
+	}
+
+	return resp.Err
+}
+
+// Calls returns the commands received so far. The Stdin of each reads
+// what it was given on stdin, once the call has returned.
+func (f *FakeExecutor) Calls() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := make([]Command, len(f.calls))
+	for i := range f.calls {
+		calls[i] = f.call(i)
+	}
+	return calls
+}
+
+// LastCall returns the most recent command, if any.
+func (f *FakeExecutor) LastCall() (Command, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.calls) == 0 {
+		return Command{}, false
+	}
+	return f.call(len(f.calls) - 1), true
+}
+
+func (f *FakeExecutor) call(i int) Command {
+	c := f.calls[i]
+	c.Stdin = strings.NewReader(*f.stdin[i])
+	return c
+}
+
+// Reset forgets recorded calls and queued responses.
+func (f *FakeExecutor) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = nil
+	f.stdin = nil
+	f.Responses = nil
+}
+
+func copyCommand(cmd *Command) Command {
+	c := *cmd
+	c.Args = append([]string(nil), cmd.Args...)
+	if cmd.Params != nil {
+		c.Params = make(map[string]interface{}, len(cmd.Params))
+		for k, v := range cmd.Params {
+			c.Params[k] = v
+		}
+	}
+	c.Stdin = nil
+	c.Stdout = nil
+	c.Stderr = nil
+	return c
+}
+
+// redactCredential replaces the password in the credential line written
+// by credentialInput, if stdin has one.
+func redactCredential(stdin string) string {
+	lines := strings.SplitAfter(stdin, "\n")
+	for i, line := range lines {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+		var credential map[string]string
+		if json.Unmarshal(data, &credential) != nil || len(credential) != 2 || credential["userName"] == "" || credential["password"] == "" {
+			continue
+		}
+
+		userName, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(credential["userName"], "s:"))
+		if err != nil {
+			continue
+		}
+		redactedLine, err := credentialInput(Credential{UserName: string(userName), Password: redacted})
+		if err != nil {
+			continue
+		}
+		lines[i] = strings.TrimSuffix(redactedLine, "\n") + line[len(strings.TrimRight(line, "\r\n")):]
+	}
+	return strings.Join(lines, "")
+}
+
+func writeString(w io.Writer, s string) error {
+	if w == nil || s == "" {
+		return nil
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
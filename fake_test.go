@@ -0,0 +1,124 @@
+package psremote
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFakeExecutorResponses(t *testing.T) {
+	fake := NewFakeExecutor(FakeResponse{Stdout: "first"})
+	fake.Push("second", "", nil)
+	fake.Push("", "", &ExitError{Code: 2})
+	ps := &PSRemote{PowerShellPath: "pwsh", Executor: fake}
+
+	for _, want := range []string{"first", "second"} {
+		out, err := ps.Output("'x'", nil)
+		if err != nil || out != want {
+			t.Errorf("Output = %q, %v; want %q", out, err, want)
+		}
+	}
+
+	_, err := ps.Output("'x'", nil)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.ExitCode != 2 {
+		t.Errorf("error %v, want exit code 2", err)
+	}
+
+	// The queue is exhausted.
+	if out, err := ps.Output("'x'", nil); err != nil || out != "" {
+		t.Errorf("Output = %q, %v; want no output", out, err)
+	}
+
+	if n := len(fake.Calls()); n != 4 {
+		t.Errorf("%d calls, want 4", n)
+	}
+	fake.Reset()
+	if _, ok := fake.LastCall(); ok {
+		t.Error("calls kept after Reset")
+	}
+}
+
+func TestFakeExecutorHandler(t *testing.T) {
+	fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		data, _ := ioutil.ReadAll(cmd.Stdin)
+		return FakeResponse{Stdout: "got " + string(data)}
+	}}
+
+	cmd := &Command{Path: "pwsh", Args: []string{"-NoProfile"}, Params: map[string]interface{}{"a": 1}, Stdin: strings.NewReader("input")}
+	var stdout strings.Builder
+	cmd.Stdout = &stdout
+	if err := fake.Run(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "got input" {
+		t.Errorf("stdout %q, want the handler to read stdin", stdout.String())
+	}
+
+	// Recorded calls are copies, and their stdin can be read again.
+	cmd.Args[0] = "changed"
+	cmd.Params["a"] = 2
+	for i := 0; i < 2; i++ {
+		call, _ := fake.LastCall()
+		data, _ := ioutil.ReadAll(call.Stdin)
+		if string(data) != "input" || call.Args[0] != "-NoProfile" || call.Params["a"] != 1 {
+			t.Errorf("recorded call %+v with stdin %q", call, data)
+		}
+	}
+}
+
+func TestFakeExecutorCancelled(t *testing.T) {
+	fake := NewFakeExecutor()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := fake.Run(ctx, &Command{}); err != context.Canceled {
+		t.Errorf("error %v, want context.Canceled", err)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Error("a cancelled call was recorded")
+	}
+}
+
+func TestFakeExecutorRecordsStdin(t *testing.T) {
+	fake := NewFakeExecutor()
+	ps := &PSRemote{
+		ComputerName:   "host",
+		UserName:       "admin",
+		Password:       "hunter2",
+		PowerShellPath: "pwsh",
+		ScriptMode:     ScriptStdin,
+		Executor:       fake,
+	}
+	params := map[string]interface{}{"name": "web"}
+
+	if _, err := ps.OutputWinRm("Get-VM $using:name", params); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := fake.LastCall()
+	data, _ := ioutil.ReadAll(call.Stdin)
+	stdin := string(data)
+
+	if strings.Contains(stdin, "hunter2") || strings.Contains(stdin, base64.StdEncoding.EncodeToString([]byte("hunter2"))) {
+		t.Error("the password was recorded")
+	}
+
+	redactedLine, _ := credentialInput(Credential{UserName: "admin", Password: redacted})
+	if !strings.Contains(stdin, redactedLine) {
+		t.Errorf("stdin %q has no redacted credential line", stdin)
+	}
+
+	// The script and its parameters are sent before the credential.
+	script, err := base64.StdEncoding.DecodeString(strings.SplitN(stdin, "\n", 2)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	serialized, _ := serializeParams(params)
+	if !strings.Contains(string(script), serialized) {
+		t.Error("the parameters are not sent on stdin")
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/nimerix/psremote
+
+go 1.21
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/crypto v0.24.0
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -0,0 +1,196 @@
+package psremote
+
+import (
+	"context"
+	"fmt"
+)
+
+// The host helpers run on ComputerName through OutputWinRm. Their scripts
+// refer to parameters with $using:, which is stripped for the Local
+// variants that run on this machine instead.
+
+const hostAvailableMemoryScript = `(Get-CimInstance -ClassName Win32_OperatingSystem).FreePhysicalMemory / 1024`
+
+const hostNameScript = `
+$ip = $using:ip
+try {
+  $HostName = [System.Net.Dns]::GetHostEntry($ip).HostName
+  if ($HostName -ne $null) {
+    $HostName = $HostName.Split('.')[0]
+  }
+  $HostName
+} catch { }
+`
+
+const isAdministratorScript = `
+$identity = [System.Security.Principal.WindowsIdentity]::GetCurrent()
+$principal = new-object System.Security.Principal.WindowsPrincipal($identity)
+$administratorRole = [System.Security.Principal.WindowsBuiltInRole]::Administrator
+$principal.IsInRole($administratorRole)
+`
+
+const moduleExistsScript = `
+$moduleName = $using:moduleName
+$null -ne (Get-Module -Name $moduleName)
+`
+
+const moduleAvailableScript = `
+$moduleName = $using:moduleName
+$null -ne (Get-Module -ListAvailable -Name $moduleName)
+`
+
+const virtualizationExtensionsScript = `
+(Get-Command Set-VMProcessor).Parameters.Keys -contains "ExposeVirtualizationExtensions"
+`
+
+// GetHostAvailableMemory returns the free physical memory of ComputerName
+// in MB.
+func (ps *PSRemote) GetHostAvailableMemory() (float64, error) {
+	return ps.GetHostAvailableMemoryContext(context.Background())
+}
+
+func (ps *PSRemote) GetHostAvailableMemoryContext(ctx context.Context) (float64, error) {
+	return ps.hostAvailableMemory(ctx, false)
+}
+
+// GetHostName resolves ip to a short host name from ComputerName. It
+// returns "" when ip does not resolve.
+func (ps *PSRemote) GetHostName(ip string) (string, error) {
+	return ps.GetHostNameContext(context.Background(), ip)
+}
+
+func (ps *PSRemote) GetHostNameContext(ctx context.Context, ip string) (string, error) {
+	return ps.hostName(ctx, false, ip)
+}
+
+// IsCurrentUserAnAdministrator reports whether the remote user is an
+// administrator on ComputerName.
+func (ps *PSRemote) IsCurrentUserAnAdministrator() (bool, error) {
+	return ps.IsCurrentUserAnAdministratorContext(context.Background())
+}
+
+func (ps *PSRemote) IsCurrentUserAnAdministratorContext(ctx context.Context) (bool, error) {
+	return ps.isAdministrator(ctx, false)
+}
+
+// ModuleExists reports whether moduleName is loaded in the session on
+// ComputerName. When it is not the error matches ErrModuleNotLoaded. Use
+// ModuleAvailable for modules that are installed but not yet imported.
+func (ps *PSRemote) ModuleExists(moduleName string) (bool, error) {
+	return ps.ModuleExistsContext(context.Background(), moduleName)
+}
+
+func (ps *PSRemote) ModuleExistsContext(ctx context.Context, moduleName string) (bool, error) {
+	return ps.moduleExists(ctx, false, moduleName)
+}
+
+// ModuleAvailable reports whether moduleName is installed on ComputerName,
+// whether or not it has been imported.
+func (ps *PSRemote) ModuleAvailable(moduleName string) (bool, error) {
+	return ps.ModuleAvailableContext(context.Background(), moduleName)
+}
+
+func (ps *PSRemote) ModuleAvailableContext(ctx context.Context, moduleName string) (bool, error) {
+	return ps.moduleAvailable(ctx, false, moduleName)
+}
+
+// HasVirtualMachineVirtualizationExtensions reports whether Hyper-V on
+// ComputerName can expose virtualization extensions to guests, which
+// nested virtualization needs.
+func (ps *PSRemote) HasVirtualMachineVirtualizationExtensions() (bool, error) {
+	return ps.HasVirtualMachineVirtualizationExtensionsContext(context.Background())
+}
+
+func (ps *PSRemote) HasVirtualMachineVirtualizationExtensionsContext(ctx context.Context) (bool, error) {
+	return ps.virtualizationExtensions(ctx, false)
+}
+
+// LocalGetHostAvailableMemory is GetHostAvailableMemory for this machine.
+func LocalGetHostAvailableMemory() (float64, error) {
+	var ps PSRemote
+	return ps.hostAvailableMemory(context.Background(), true)
+}
+
+// LocalGetHostName is GetHostName resolving from this machine.
+func LocalGetHostName(ip string) (string, error) {
+	var ps PSRemote
+	return ps.hostName(context.Background(), true, ip)
+}
+
+// LocalIsCurrentUserAnAdministrator is IsCurrentUserAnAdministrator for the
+// user running this process.
+func LocalIsCurrentUserAnAdministrator() (bool, error) {
+	var ps PSRemote
+	return ps.isAdministrator(context.Background(), true)
+}
+
+// LocalModuleExists is ModuleExists for this machine.
+func LocalModuleExists(moduleName string) (bool, error) {
+	var ps PSRemote
+	return ps.moduleExists(context.Background(), true, moduleName)
+}
+
+// LocalModuleAvailable is ModuleAvailable for this machine.
+func LocalModuleAvailable(moduleName string) (bool, error) {
+	var ps PSRemote
+	return ps.moduleAvailable(context.Background(), true, moduleName)
+}
+
+// LocalHasVirtualMachineVirtualizationExtensions is
+// HasVirtualMachineVirtualizationExtensions for this machine.
+func LocalHasVirtualMachineVirtualizationExtensions() (bool, error) {
+	var ps PSRemote
+	return ps.virtualizationExtensions(context.Background(), true)
+}
+
+func (ps *PSRemote) hostAvailableMemory(ctx context.Context, local bool) (float64, error) {
+	var freeMB float64
+	err := ps.hostJSON(ctx, local, hostAvailableMemoryScript, nil, &freeMB)
+	return freeMB, err
+}
+
+func (ps *PSRemote) hostName(ctx context.Context, local bool, ip string) (string, error) {
+	var hostName string
+	err := ps.hostJSON(ctx, local, hostNameScript, map[string]interface{}{"ip": ip}, &hostName)
+	return hostName, err
+}
+
+func (ps *PSRemote) isAdministrator(ctx context.Context, local bool) (bool, error) {
+	var isAdministrator bool
+	err := ps.hostJSON(ctx, local, isAdministratorScript, nil, &isAdministrator)
+	return isAdministrator, err
+}
+
+func (ps *PSRemote) moduleExists(ctx context.Context, local bool, moduleName string) (bool, error) {
+	var exists bool
+	if err := ps.hostJSON(ctx, local, moduleExistsScript, map[string]interface{}{"moduleName": moduleName}, &exists); err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, fmt.Errorf("%w: %s, make sure the %s feature is on", ErrModuleNotLoaded, moduleName, moduleName)
+	}
+
+	return true, nil
+}
+
+func (ps *PSRemote) moduleAvailable(ctx context.Context, local bool, moduleName string) (bool, error) {
+	var available bool
+	err := ps.hostJSON(ctx, local, moduleAvailableScript, map[string]interface{}{"moduleName": moduleName}, &available)
+	return available, err
+}
+
+func (ps *PSRemote) virtualizationExtensions(ctx context.Context, local bool) (bool, error) {
+	var hasExtensions bool
+	err := ps.hostJSON(ctx, local, virtualizationExtensionsScript, nil, &hasExtensions)
+	return hasExtensions, err
+}
+
+// hostJSON runs a host helper script on ComputerName, or on this machine
+// when local is set, and decodes its result into v.
+func (ps *PSRemote) hostJSON(ctx context.Context, local bool, script string, params map[string]interface{}, v interface{}) error {
+	if local {
+		return ps.OutputJSONContext(ctx, usingPattern.ReplaceAllString(script, "$$"), params, v)
+	}
+	return ps.OutputWinRmJSONContext(ctx, script, params, v)
+}
@@ -0,0 +1,43 @@
+package psremote
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestModuleExists(t *testing.T) {
+	fake := NewFakeExecutor(FakeResponse{Stdout: "true\n"}, FakeResponse{Stdout: "false\n"})
+	ps := &PSRemote{ComputerName: "host", PowerShellPath: "pwsh", Executor: fake}
+
+	if exists, err := ps.ModuleExists("Hyper-V"); err != nil || !exists {
+		t.Errorf("ModuleExists = %v, %v; want true", exists, err)
+	}
+	call, _ := fake.LastCall()
+	if !strings.Contains(call.Script, "Get-Module -Name $moduleName") {
+		t.Error("ModuleExists does not look at loaded modules")
+	}
+
+	exists, err := ps.ModuleExists("Hyper-V")
+	if exists || !errors.Is(err, ErrModuleNotLoaded) {
+		t.Errorf("ModuleExists = %v, %v; want ErrModuleNotLoaded", exists, err)
+	}
+}
+
+func TestModuleAvailable(t *testing.T) {
+	fake := NewFakeExecutor(FakeResponse{Stdout: "true\n"}, FakeResponse{Stdout: "false\n"})
+	ps := &PSRemote{ComputerName: "host", PowerShellPath: "pwsh", Executor: fake}
+
+	if available, err := ps.ModuleAvailable("Hyper-V"); err != nil || !available {
+		t.Errorf("ModuleAvailable = %v, %v; want true", available, err)
+	}
+	call, _ := fake.LastCall()
+	if !strings.Contains(call.Script, "Get-Module -ListAvailable -Name $moduleName") {
+		t.Error("ModuleAvailable does not look at installed modules")
+	}
+
+	// A missing module is not an error.
+	if available, err := ps.ModuleAvailable("Hyper-V"); err != nil || available {
+		t.Errorf("ModuleAvailable = %v, %v; want false", available, err)
+	}
+}
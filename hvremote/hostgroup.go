@@ -0,0 +1,169 @@
+package hvremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrHostSkipped is the error recorded for hosts that were not reached
+// because a fail-fast HostGroup stopped at another host's failure.
+var ErrHostSkipped = errors.New("host skipped after an earlier failure")
+
+// HostGroup runs the same operation on many Hyper-V hosts at once. Hosts
+// are told apart by Ps.ComputerName, which must be unique in the group.
+type HostGroup struct {
+	Hosts []*HypervRemote
+	// Concurrency limits how many hosts are worked on at a time. Zero
+	// works on all of them at once.
+	Concurrency int
+	// FailFast stops at the first failure: operations still running are
+	// cancelled and hosts not yet started are recorded with
+	// ErrHostSkipped. Otherwise every host is tried.
+	FailFast bool
+}
+
+// HostResult is the outcome of an operation on one host.
+type HostResult struct {
+	Value interface{}
+	Err   error
+}
+
+// HostGroupError is returned when the operation failed on at least one
+// host. Errors holds the failure of each host that failed, by
+// ComputerName.
+type HostGroupError struct {
+	Errors map[string]error
+}
+
+func (e *HostGroupError) Error() string {
+	hosts := make([]string, 0, len(e.Errors))
+	for host := range e.Errors {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	failures := make([]string, len(hosts))
+	for i, host := range hosts {
+		failures[i] = host + ": " + e.Errors[host].Error()
+	}
+	return fmt.Sprintf("%d of the hosts failed: %s", len(hosts), strings.Join(failures, "; "))
+}
+
+// NewHostGroup returns a best-effort group of hosts without a concurrency
+// limit.
+func NewHostGroup(hosts ...*HypervRemote) *HostGroup {
+	return &HostGroup{Hosts: hosts}
+}
+
+// Do calls op for every host and returns the result of each by
+// ComputerName. When op fails anywhere the error is a *HostGroupError, and
+// the results are still returned.
+func (g *HostGroup) Do(op func(ctx context.Context, hvc *HypervRemote) (interface{}, error)) (map[string]HostResult, error) {
+	return g.DoContext(context.Background(), op)
+}
+
+func (g *HostGroup) DoContext(ctx context.Context, op func(ctx context.Context, hvc *HypervRemote) (interface{}, error)) (map[string]HostResult, error) {
+	seen := make(map[string]bool, len(g.Hosts))
+	for _, hvc := range g.Hosts {
+		name := hvc.configured().Ps.ComputerName
+		if seen[name] {
+			return nil, fmt.Errorf("host %s is in the group more than once", name)
+		}
+		seen[name] = true
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	limit := g.Concurrency
+	if limit <= 0 || limit > len(g.Hosts) {
+		limit = len(g.Hosts)
+	}
+	slots := make(chan struct{}, limit)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]HostResult, len(g.Hosts))
+		failed  bool
+	)
+
+	for _, hvc := range g.Hosts {
+		slots <- struct{}{}
+
+		mu.Lock()
+		stop := failed && g.FailFast
+		mu.Unlock()
+		if stop {
+			<-slots
+			mu.Lock()
+			results[hvc.configured().Ps.ComputerName] = HostResult{Err: ErrHostSkipped}
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(hvc *HypervRemote) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			value, err := op(ctx, hvc)
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[hvc.configured().Ps.ComputerName] = HostResult{Value: value, Err: err}
+			if err != nil {
+				failed = true
+				if g.FailFast {
+					cancel()
+				}
+			}
+		}(hvc)
+	}
+
+	wg.Wait()
+
+	errs := make(map[string]error)
+	for name, result := range results {
+		if result.Err != nil {
+			errs[name] = result.Err
+		}
+	}
+	if len(errs) > 0 {
+		return results, &HostGroupError{Errors: errs}
+	}
+	return results, nil
+}
+
+// InvokeCommand runs scriptBlock on every host, see
+// HypervRemote.InvokeCommand. Each result's Value is the script's output
+// as a string.
+func (g *HostGroup) InvokeCommand(scriptBlock string, params map[string]interface{}) (map[string]HostResult, error) {
+	return g.InvokeCommandContext(context.Background(), scriptBlock, params)
+}
+
+func (g *HostGroup) InvokeCommandContext(ctx context.Context, scriptBlock string, params map[string]interface{}) (map[string]HostResult, error) {
+	return g.DoContext(ctx, func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+		return hvc.InvokeCommandContext(ctx, scriptBlock, params)
+	})
+}
+
+// Preflight checks every host, see HypervRemote.Preflight. Each result's
+// Value is a *HostReport.
+func (g *HostGroup) Preflight() (map[string]HostResult, error) {
+	return g.PreflightContext(context.Background())
+}
+
+func (g *HostGroup) PreflightContext(ctx context.Context) (map[string]HostResult, error) {
+	return g.DoContext(ctx, func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+		report, err := hvc.PreflightContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return report, nil
+	})
+}
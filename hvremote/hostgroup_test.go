@@ -0,0 +1,160 @@
+package hvremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nimerix/psremote"
+)
+
+func newTestGroup(names ...string) *HostGroup {
+	hosts := make([]*HypervRemote, len(names))
+	for i, name := range names {
+		hosts[i] = &HypervRemote{Ps: &psremote.PSRemote{ComputerName: name}}
+	}
+	return NewHostGroup(hosts...)
+}
+
+func TestHostGroupBestEffort(t *testing.T) {
+	group := newTestGroup("hv1", "hv2", "hv3", "hv4")
+	boom := errors.New("boom")
+
+	results, err := group.Do(func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+		if hvc.Ps.ComputerName == "hv2" {
+			return nil, boom
+		}
+		return "up on " + hvc.Ps.ComputerName, nil
+	})
+
+	var groupErr *HostGroupError
+	if !errors.As(err, &groupErr) || len(groupErr.Errors) != 1 || groupErr.Errors["hv2"] != boom {
+		t.Fatalf("error %v, want a *HostGroupError for hv2", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("%d results, want 4", len(results))
+	}
+	for _, name := range []string{"hv1", "hv3", "hv4"} {
+		if r := results[name]; r.Err != nil || r.Value != "up on "+name {
+			t.Errorf("%s: result %+v", name, r)
+		}
+	}
+	if results["hv2"].Err != boom {
+		t.Errorf("hv2: result %+v", results["hv2"])
+	}
+}
+
+func TestHostGroupFailFast(t *testing.T) {
+	group := newTestGroup("hv1", "hv2", "hv3")
+	group.FailFast = true
+	group.Concurrency = 2
+	boom := errors.New("boom")
+
+	var (
+		mu    sync.Mutex
+		ran   []string
+		start = make(chan struct{})
+	)
+	results, err := group.Do(func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+		mu.Lock()
+		ran = append(ran, hvc.Ps.ComputerName)
+		mu.Unlock()
+
+		switch hvc.Ps.ComputerName {
+		case "hv1":
+			// Fail only once hv2 is running, so there is something to
+			// cancel.
+			<-start
+			return nil, boom
+		case "hv2":
+			close(start)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return "not cancelled", nil
+			}
+		}
+		return "ran", nil
+	})
+
+	var groupErr *HostGroupError
+	if !errors.As(err, &groupErr) || len(groupErr.Errors) != 3 {
+		t.Fatalf("error %v, want a *HostGroupError for every host", err)
+	}
+	if results["hv1"].Err != boom {
+		t.Errorf("hv1: result %+v", results["hv1"])
+	}
+	if !errors.Is(results["hv2"].Err, context.Canceled) {
+		t.Errorf("hv2 was not cancelled: %+v", results["hv2"])
+	}
+	if results["hv3"].Err != ErrHostSkipped {
+		t.Errorf("hv3 was not skipped: %+v", results["hv3"])
+	}
+	if len(ran) != 2 {
+		t.Errorf("op ran for %v", ran)
+	}
+}
+
+func TestHostGroupConcurrency(t *testing.T) {
+	var names []string
+	for i := 1; i <= 8; i++ {
+		names = append(names, fmt.Sprintf("hv%d", i))
+	}
+	group := newTestGroup(names...)
+	group.Concurrency = 3
+
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	results, err := group.Do(func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return hvc.Ps.ComputerName, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if peak != 3 {
+		t.Errorf("%d hosts at a time, want 3", peak)
+	}
+	for _, name := range names {
+		if results[name].Value != name {
+			t.Errorf("%s: result %+v", name, results[name])
+		}
+	}
+}
+
+func TestHostGroupDuplicateHost(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		group := newTestGroup("hv1", "hv2", "hv1")
+		group.FailFast = failFast
+
+		called := false
+		results, err := group.Do(func(ctx context.Context, hvc *HypervRemote) (interface{}, error) {
+			called = true
+			return nil, nil
+		})
+		if err == nil || results != nil {
+			t.Errorf("fail fast %v: results %v, error %v", failFast, results, err)
+		}
+		if called {
+			t.Errorf("fail fast %v: op ran with a duplicate host", failFast)
+		}
+	}
+}
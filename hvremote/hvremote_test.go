@@ -0,0 +1,104 @@
+package hvremote
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nimerix/psremote"
+)
+
+func TestConcurrentIsRunning(t *testing.T) {
+	var running, peak int32
+	fake := &psremote.FakeExecutor{Handler: func(cmd *psremote.Command) psremote.FakeResponse {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		return psremote.FakeResponse{Stdout: fmt.Sprint(cmd.Params["vmName"] == "on")}
+	}}
+	hvc := &HypervRemote{Ps: &psremote.PSRemote{
+		ComputerName:   "host",
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+		Limiter:        psremote.NewHostLimiter(3),
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		vmName := []string{"on", "off"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			isRunning, err := hvc.IsRunning(vmName)
+			if err != nil {
+				t.Error(err)
+			} else if isRunning != (vmName == "on") {
+				t.Errorf("IsRunning(%s) = %v", vmName, isRunning)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > 3 {
+		t.Errorf("%d calls ran at once, limit is 3", peak)
+	}
+}
+
+func TestScriptErrorNamesScript(t *testing.T) {
+	fake := &psremote.FakeExecutor{Handler: func(cmd *psremote.Command) psremote.FakeResponse {
+		return psremote.FakeResponse{Err: &psremote.ExitError{Code: 1}}
+	}}
+	hvc := &HypervRemote{Ps: &psremote.PSRemote{ComputerName: "host", PowerShellPath: "pwsh", Executor: fake}}
+
+	_, err := hvc.IsRunning("vm")
+
+	var scriptErr *psremote.ScriptError
+	if !errors.As(err, &scriptErr) {
+		t.Fatalf("error %v, want a *psremote.ScriptError", err)
+	}
+	if scriptErr.Script != "IsRunning" {
+		t.Errorf("Script %q, want IsRunning", scriptErr.Script)
+	}
+}
+
+func TestOptionalParamsAreSent(t *testing.T) {
+	var params []map[string]interface{}
+	fake := &psremote.FakeExecutor{Handler: func(cmd *psremote.Command) psremote.FakeResponse {
+		params = append(params, cmd.Params)
+		return psremote.FakeResponse{Stdout: `"id"`}
+	}}
+	hvc := &HypervRemote{Ps: &psremote.PSRemote{ComputerName: "host", PowerShellPath: "pwsh", Executor: fake}}
+
+	given := map[string]interface{}{"Name": "external"}
+	if _, err := hvc.GetVirtualSwitchId(given); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hvc.GetVirtualSwitchId(map[string]interface{}{"id": "1234"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(given) != 1 {
+		t.Errorf("caller's params changed to %v", given)
+	}
+	for _, want := range []map[string]interface{}{
+		{"Name": "external", "Id": nil},
+		{"id": "1234", "Name": nil},
+	} {
+		got := params[0]
+		params = params[1:]
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("params %v, want %v", got, want)
+		}
+	}
+}
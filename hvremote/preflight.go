@@ -0,0 +1,380 @@
+package hvremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nimerix/psremote"
+)
+
+// CheckStatus is the outcome of one preflight check.
+type CheckStatus int
+
+const (
+	// CheckPass means the host is ready in this respect.
+	CheckPass CheckStatus = iota
+	// CheckWarn means provisioning may work but something should be looked
+	// at.
+	CheckWarn
+	// CheckFail means provisioning will not work until it is fixed.
+	CheckFail
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckPass:
+		return "pass"
+	case CheckWarn:
+		return "warn"
+	case CheckFail:
+		return "fail"
+	}
+	return fmt.Sprintf("CheckStatus(%d)", int(s))
+}
+
+// Names of the checks in a HostReport, in the order they are run.
+const (
+	CheckHyperVModule          = "Hyper-V module"
+	CheckHyperVRole            = "Hyper-V role"
+	CheckAdministrator         = "administrator"
+	CheckNestedVirtualization  = "nested virtualization"
+	CheckConfigurationVersions = "configuration versions"
+	CheckFreeMemory            = "free memory"
+	CheckDefaultPaths          = "default paths"
+	CheckSwitches              = "virtual switches"
+	CheckWinRM                 = "WinRM"
+)
+
+// Free memory below these is reported as a warning or a failure.
+const (
+	freeMemoryWarnMB = 4096
+	freeMemoryFailMB = 1024
+)
+
+// WinRM shells with less memory than this cannot copy large files or run
+// the export scripts.
+const winRMMinMemoryPerShellMB = 1024
+
+// Check is the outcome of one preflight check. Remediation says how to fix
+// a warning or failure and is empty when the check passed.
+type Check struct {
+	Name        string
+	Status      CheckStatus
+	Detail      string
+	Remediation string
+}
+
+func (c Check) String() string {
+	s := c.Status.String() + " " + c.Name
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// HostReport describes whether a host is usable for provisioning. The
+// facts gathered are kept alongside the checks made from them, and are
+// left at their zero value when they could not be read.
+type HostReport struct {
+	ComputerName string
+
+	HyperVModule                bool
+	HyperVRole                  string
+	HypervisorPresent           bool
+	Administrator               bool
+	HyperVAdministrator         bool
+	VirtualizationExtensions    bool
+	ConfigurationVersions       []string
+	DefaultConfigurationVersion string
+	FreeMemoryMB                float64
+	VirtualHardDiskPath         string
+	VirtualMachinePath          string
+	Switches                    []string
+	WinRMMaxMemoryPerShellMB    int
+
+	Checks []Check
+}
+
+// Status returns the worst status of all checks.
+func (r *HostReport) Status() CheckStatus {
+	status := CheckPass
+	for _, c := range r.Checks {
+		if c.Status > status {
+			status = c.Status
+		}
+	}
+	return status
+}
+
+// Check returns the check called name.
+func (r *HostReport) Check(name string) (Check, bool) {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Check{}, false
+}
+
+func (r *HostReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n", r.ComputerName, r.Status())
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "  %s\n", c)
+		if c.Remediation != "" {
+			fmt.Fprintf(&b, "    %s\n", c.Remediation)
+		}
+	}
+	return b.String()
+}
+
+func (r *HostReport) add(name string, status CheckStatus, detail, remediation string) {
+	if status == CheckPass {
+		remediation = ""
+	}
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: detail, Remediation: remediation})
+}
+
+// preflightFacts is gathered in one call. Everything after HyperVModule is
+// only read when the module is available, and WinRM settings need an
+// administrator, so any of it may be missing. Errors holds why a fact
+// could not be read, keyed by the fact's name.
+type preflightFacts struct {
+	HyperVModule                bool
+	HyperVRole                  string
+	HypervisorPresent           bool
+	HyperVAdministrator         bool
+	ConfigurationVersions       []string
+	DefaultConfigurationVersion string
+	VirtualHardDiskPath         string
+	VirtualHardDiskPathExists   bool
+	VirtualMachinePath          string
+	VirtualMachinePathExists    bool
+	Switches                    []string
+	WinRMMaxMemoryPerShellMB    int
+	Errors                      map[string]string
+}
+
+// unreadable adds to detail why fact could not be read, when the script
+// said.
+func (f *preflightFacts) unreadable(detail, fact string) string {
+	if message := f.Errors[fact]; message != "" {
+		return detail + ": " + message
+	}
+	return detail
+}
+
+// Preflight checks whether the host can be used for provisioning: the
+// Hyper-V module and role, administrator rights, nested virtualization,
+// VM configuration versions, free memory, the default VHD and VM paths,
+// virtual switches and WinRM settings.
+//
+// Failed checks are reported in the HostReport. An error is only returned
+// when the host cannot be asked at all.
+func (hvc *HypervRemote) Preflight() (*HostReport, error) {
+	return hvc.PreflightContext(context.Background())
+}
+
+func (hvc *HypervRemote) PreflightContext(ctx context.Context) (*HostReport, error) {
+	hvc = hvc.configured()
+	ctx = hvc.retrySafe(ctx)
+
+	var facts preflightFacts
+	if err := hvc.outputJSON(ctx, "Preflight", nil, &facts); err != nil {
+		return nil, err
+	}
+
+	report := &HostReport{
+		ComputerName:                hvc.Ps.ComputerName,
+		HyperVModule:                facts.HyperVModule,
+		HyperVRole:                  facts.HyperVRole,
+		HypervisorPresent:           facts.HypervisorPresent,
+		HyperVAdministrator:         facts.HyperVAdministrator,
+		ConfigurationVersions:       facts.ConfigurationVersions,
+		DefaultConfigurationVersion: facts.DefaultConfigurationVersion,
+		VirtualHardDiskPath:         facts.VirtualHardDiskPath,
+		VirtualMachinePath:          facts.VirtualMachinePath,
+		Switches:                    facts.Switches,
+		WinRMMaxMemoryPerShellMB:    facts.WinRMMaxMemoryPerShellMB,
+	}
+
+	switch {
+	case facts.HyperVModule:
+		report.add(CheckHyperVModule, CheckPass, "", "")
+	case facts.Errors["HyperVModule"] != "":
+		report.add(CheckHyperVModule, CheckFail, facts.unreadable("the installed modules could not be listed", "HyperVModule"),
+			"See the PowerShell error for the cause")
+	default:
+		report.add(CheckHyperVModule, CheckFail, "the Hyper-V PowerShell module is not installed",
+			"Install-WindowsFeature -Name Hyper-V-PowerShell, or Enable-WindowsOptionalFeature -Online -FeatureName Microsoft-Hyper-V-Management-PowerShell on Windows client")
+	}
+
+	switch {
+	case facts.HyperVRole == "":
+		report.add(CheckHyperVRole, CheckWarn, facts.unreadable("the Hyper-V role state could not be read", "HyperVRole"),
+			"Check the Hyper-V role with an administrator account")
+	case facts.HyperVRole != "Installed" && facts.HyperVRole != "Enabled":
+		report.add(CheckHyperVRole, CheckFail, "the Hyper-V role is "+facts.HyperVRole,
+			"Install-WindowsFeature -Name Hyper-V -IncludeManagementTools -Restart, or Enable-WindowsOptionalFeature -Online -FeatureName Microsoft-Hyper-V-All on Windows client")
+	case facts.Errors["HypervisorPresent"] != "":
+		report.add(CheckHyperVRole, CheckWarn, facts.unreadable("the Hyper-V role is "+facts.HyperVRole+" but whether the hypervisor is running could not be read", "HypervisorPresent"),
+			"Check that the hypervisor is running with systeminfo")
+	case !facts.HypervisorPresent:
+		report.add(CheckHyperVRole, CheckFail, "the Hyper-V role is installed but the hypervisor is not running",
+			"Restart the host, and make sure virtualization is enabled in the firmware and hypervisorlaunchtype is Auto in bcdedit")
+	default:
+		report.add(CheckHyperVRole, CheckPass, facts.HyperVRole, "")
+	}
+
+	if err := hvc.checkAdministrator(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := hvc.checkNestedVirtualization(ctx, report); err != nil {
+		return nil, err
+	}
+
+	checkConfigurationVersions(report, &facts)
+
+	if err := hvc.checkFreeMemory(ctx, report); err != nil {
+		return nil, err
+	}
+
+	checkDefaultPaths(report, &facts)
+	checkSwitches(report, &facts)
+	checkWinRM(report, &facts)
+
+	return report, nil
+}
+
+func (hvc *HypervRemote) checkAdministrator(ctx context.Context, report *HostReport) error {
+	hvc = hvc.configured()
+	isAdministrator, err := hvc.Ps.IsCurrentUserAnAdministratorContext(ctx)
+	if err != nil {
+		return checkError(report, CheckAdministrator, err)
+	}
+
+	report.Administrator = isAdministrator
+	switch {
+	case isAdministrator:
+		report.add(CheckAdministrator, CheckPass, "", "")
+	case report.HyperVAdministrator:
+		report.add(CheckAdministrator, CheckWarn, "the user is a Hyper-V Administrator but not an administrator",
+			"VMs can be managed, but role and WinRM checks and some file operations need a member of Administrators")
+	default:
+		report.add(CheckAdministrator, CheckFail, "the user is not an administrator",
+			"Connect as a member of Administrators or Hyper-V Administrators")
+	}
+	return nil
+}
+
+func (hvc *HypervRemote) checkNestedVirtualization(ctx context.Context, report *HostReport) error {
+	hvc = hvc.configured()
+	if !report.HyperVModule {
+		report.add(CheckNestedVirtualization, CheckFail, "needs the Hyper-V module", "Install the Hyper-V module first")
+		return nil
+	}
+
+	hasExtensions, err := hvc.Ps.HasVirtualMachineVirtualizationExtensionsContext(ctx)
+	if err != nil {
+		return checkError(report, CheckNestedVirtualization, err)
+	}
+
+	report.VirtualizationExtensions = hasExtensions
+	if hasExtensions {
+		report.add(CheckNestedVirtualization, CheckPass, "", "")
+	} else {
+		report.add(CheckNestedVirtualization, CheckWarn, "VMs cannot be given virtualization extensions",
+			"Nested virtualization needs Windows Server 2016 or Windows 10 and later; only needed for VMs that run their own hypervisor")
+	}
+	return nil
+}
+
+func checkConfigurationVersions(report *HostReport, facts *preflightFacts) {
+	switch {
+	case !report.HyperVModule:
+		report.add(CheckConfigurationVersions, CheckFail, "needs the Hyper-V module", "Install the Hyper-V module first")
+	case len(report.ConfigurationVersions) == 0:
+		report.add(CheckConfigurationVersions, CheckWarn, facts.unreadable("the supported VM configuration versions could not be read", "ConfigurationVersions"),
+			"Get-VMHostSupportedVersion needs Windows Server 2016 or Windows 10 and later; older hosts only create version 5.0 VMs")
+	default:
+		report.add(CheckConfigurationVersions, CheckPass,
+			"default "+report.DefaultConfigurationVersion+", supported "+strings.Join(report.ConfigurationVersions, ", "), "")
+	}
+}
+
+func (hvc *HypervRemote) checkFreeMemory(ctx context.Context, report *HostReport) error {
+	hvc = hvc.configured()
+	freeMB, err := hvc.Ps.GetHostAvailableMemoryContext(ctx)
+	if err != nil {
+		return checkError(report, CheckFreeMemory, err)
+	}
+
+	report.FreeMemoryMB = freeMB
+	detail := fmt.Sprintf("%.0f MB free", freeMB)
+	switch {
+	case freeMB < freeMemoryFailMB:
+		report.add(CheckFreeMemory, CheckFail, detail, "Stop or remove VMs, or add memory to the host")
+	case freeMB < freeMemoryWarnMB:
+		report.add(CheckFreeMemory, CheckWarn, detail, "Only small VMs can be started; stop unused VMs or add memory")
+	default:
+		report.add(CheckFreeMemory, CheckPass, detail, "")
+	}
+	return nil
+}
+
+func checkDefaultPaths(report *HostReport, facts *preflightFacts) {
+	switch {
+	case !report.HyperVModule:
+		report.add(CheckDefaultPaths, CheckFail, "needs the Hyper-V module", "Install the Hyper-V module first")
+	case facts.VirtualHardDiskPath == "" || facts.VirtualMachinePath == "":
+		report.add(CheckDefaultPaths, CheckFail, facts.unreadable("the default VHD and VM paths could not be read", "VMHost"),
+			"Set-VMHost -VirtualHardDiskPath <path> -VirtualMachinePath <path>")
+	case !facts.VirtualHardDiskPathExists || !facts.VirtualMachinePathExists:
+		report.add(CheckDefaultPaths, CheckWarn, "VHDs in "+facts.VirtualHardDiskPath+", VMs in "+facts.VirtualMachinePath+", not all exist",
+			"Create the directories, or point Set-VMHost -VirtualHardDiskPath and -VirtualMachinePath at existing ones")
+	default:
+		report.add(CheckDefaultPaths, CheckPass, "VHDs in "+facts.VirtualHardDiskPath+", VMs in "+facts.VirtualMachinePath, "")
+	}
+}
+
+func checkSwitches(report *HostReport, facts *preflightFacts) {
+	switch {
+	case !report.HyperVModule:
+		report.add(CheckSwitches, CheckFail, "needs the Hyper-V module", "Install the Hyper-V module first")
+	case facts.Errors["Switches"] != "":
+		report.add(CheckSwitches, CheckWarn, facts.unreadable("the virtual switches could not be listed", "Switches"),
+			"See the PowerShell error for the cause")
+	case len(report.Switches) == 0:
+		report.add(CheckSwitches, CheckWarn, "there are no virtual switches",
+			"Create one with New-VMSwitch or CreateVirtualSwitch before adding network adapters")
+	default:
+		report.add(CheckSwitches, CheckPass, strings.Join(report.Switches, ", "), "")
+	}
+}
+
+func checkWinRM(report *HostReport, facts *preflightFacts) {
+	maxMemory := report.WinRMMaxMemoryPerShellMB
+	switch {
+	case maxMemory == 0:
+		report.add(CheckWinRM, CheckWarn, facts.unreadable("the WinRM shell settings could not be read", "WinRM"),
+			"Reading WSMan:\\localhost needs an administrator")
+	case maxMemory < winRMMinMemoryPerShellMB:
+		report.add(CheckWinRM, CheckWarn, fmt.Sprintf("MaxMemoryPerShellMB is %d", maxMemory),
+			fmt.Sprintf("Set-Item -Path WSMan:\\localhost\\Shell\\MaxMemoryPerShellMB -Value %d", winRMMinMemoryPerShellMB))
+	default:
+		report.add(CheckWinRM, CheckPass, fmt.Sprintf("MaxMemoryPerShellMB is %d", maxMemory), "")
+	}
+}
+
+// checkError records a check whose script failed. Anything other than a
+// script failure, such as a lost connection, is returned to end the
+// preflight.
+func checkError(report *HostReport, name string, err error) error {
+	if !errors.Is(err, psremote.ErrRemoteScript) {
+		return err
+	}
+	report.add(name, CheckFail, err.Error(), "See the PowerShell error for the cause")
+	return nil
+}
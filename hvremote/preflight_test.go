@@ -0,0 +1,208 @@
+package hvremote
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nimerix/psremote"
+)
+
+var readyFacts = preflightFacts{
+	HyperVModule:                true,
+	HyperVRole:                  "Installed",
+	HypervisorPresent:           true,
+	ConfigurationVersions:       []string{"8.0", "9.0"},
+	DefaultConfigurationVersion: "9.0",
+	VirtualHardDiskPath:         `D:\VHD`,
+	VirtualHardDiskPathExists:   true,
+	VirtualMachinePath:          `D:\VM`,
+	VirtualMachinePathExists:    true,
+	Switches:                    []string{"Default Switch"},
+	WinRMMaxMemoryPerShellMB:    2048,
+	Errors:                      map[string]string{},
+}
+
+// preflightHost answers the scripts Preflight runs. Responses for the
+// helpers are keyed by a word of their script, and facts answers the
+// Preflight script itself.
+type preflightHost struct {
+	facts     preflightFacts
+	responses map[string]psremote.FakeResponse
+	ran       []string
+}
+
+func (h *preflightHost) handle(cmd *psremote.Command) psremote.FakeResponse {
+	if strings.Contains(cmd.Script, "Get-VMHostSupportedVersion") {
+		h.ran = append(h.ran, "Preflight")
+		data, _ := json.Marshal(h.facts)
+		return psremote.FakeResponse{Stdout: string(data)}
+	}
+	for key, resp := range h.responses {
+		if strings.Contains(cmd.Script, key) {
+			h.ran = append(h.ran, key)
+			return resp
+		}
+	}
+	return psremote.FakeResponse{Err: &psremote.ExitError{Code: 1}}
+}
+
+func newPreflightHost(facts preflightFacts) *preflightHost {
+	return &preflightHost{
+		facts: facts,
+		responses: map[string]psremote.FakeResponse{
+			"WindowsBuiltInRole":             {Stdout: "true"},
+			"ExposeVirtualizationExtensions": {Stdout: "true"},
+			"FreePhysicalMemory":             {Stdout: "8192"},
+		},
+	}
+}
+
+func (h *preflightHost) preflight() (*HostReport, error) {
+	hvc := &HypervRemote{Ps: &psremote.PSRemote{
+		ComputerName:   "hyperv01",
+		PowerShellPath: "pwsh",
+		Executor:       &psremote.FakeExecutor{Handler: h.handle},
+	}}
+	return hvc.Preflight()
+}
+
+func checkStatuses(t *testing.T, report *HostReport, want map[string]CheckStatus) {
+	t.Helper()
+
+	if len(report.Checks) != 9 {
+		t.Errorf("%d checks, want 9", len(report.Checks))
+	}
+	for name, status := range want {
+		check, ok := report.Check(name)
+		if !ok {
+			t.Errorf("no %s check", name)
+		} else if check.Status != status {
+			t.Errorf("%s", check)
+		}
+	}
+}
+
+func TestPreflightPass(t *testing.T) {
+	host := newPreflightHost(readyFacts)
+	report, err := host.preflight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status() != CheckPass {
+		t.Errorf("report\n%s", report)
+	}
+	for _, check := range report.Checks {
+		if check.Remediation != "" {
+			t.Errorf("%s has a remediation", check)
+		}
+	}
+	if report.ComputerName != "hyperv01" || report.FreeMemoryMB != 8192 || !report.Administrator || !report.VirtualizationExtensions {
+		t.Errorf("report %+v", report)
+	}
+}
+
+func TestPreflightFail(t *testing.T) {
+	host := newPreflightHost(preflightFacts{HyperVRole: "Available"})
+	host.responses["WindowsBuiltInRole"] = psremote.FakeResponse{Stdout: "false"}
+	host.responses["FreePhysicalMemory"] = psremote.FakeResponse{Stdout: "512"}
+
+	report, err := host.preflight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStatuses(t, report, map[string]CheckStatus{
+		CheckHyperVModule:          CheckFail,
+		CheckHyperVRole:            CheckFail,
+		CheckAdministrator:         CheckFail,
+		CheckNestedVirtualization:  CheckFail,
+		CheckConfigurationVersions: CheckFail,
+		CheckFreeMemory:            CheckFail,
+		CheckDefaultPaths:          CheckFail,
+		CheckSwitches:              CheckFail,
+		CheckWinRM:                 CheckWarn,
+	})
+	if report.Status() != CheckFail {
+		t.Errorf("status %s, want fail", report.Status())
+	}
+
+	// Nested virtualization is not asked about without the module.
+	for _, ran := range host.ran {
+		if ran == "ExposeVirtualizationExtensions" {
+			t.Error("virtualization extensions checked without the Hyper-V module")
+		}
+	}
+}
+
+func TestPreflightPartial(t *testing.T) {
+	facts := readyFacts
+	facts.Switches = nil
+	facts.WinRMMaxMemoryPerShellMB = 0
+	facts.HypervisorPresent = false
+	facts.HyperVAdministrator = true
+	facts.Errors = map[string]string{
+		"HypervisorPresent": "Access denied",
+		"Switches":          "The operation failed",
+		"WinRM":             "Access is denied",
+	}
+	host := newPreflightHost(facts)
+	host.responses["WindowsBuiltInRole"] = psremote.FakeResponse{Stdout: "false"}
+	// A helper script that fails is recorded, not returned.
+	host.responses["FreePhysicalMemory"] = psremote.FakeResponse{Err: &psremote.ExitError{Code: 1}}
+
+	report, err := host.preflight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkStatuses(t, report, map[string]CheckStatus{
+		CheckHyperVModule:          CheckPass,
+		CheckHyperVRole:            CheckWarn,
+		CheckAdministrator:         CheckWarn,
+		CheckNestedVirtualization:  CheckPass,
+		CheckConfigurationVersions: CheckPass,
+		CheckFreeMemory:            CheckFail,
+		CheckDefaultPaths:          CheckPass,
+		CheckSwitches:              CheckWarn,
+		CheckWinRM:                 CheckWarn,
+	})
+
+	for name, message := range map[string]string{
+		CheckHyperVRole: "Access denied",
+		CheckSwitches:   "The operation failed",
+		CheckWinRM:      "Access is denied",
+	} {
+		if check, _ := report.Check(name); !strings.HasSuffix(check.Detail, ": "+message) {
+			t.Errorf("%s does not say why: %s", name, check)
+		}
+	}
+}
+
+func TestPreflightModuleListFails(t *testing.T) {
+	host := newPreflightHost(preflightFacts{
+		HyperVRole: "Installed",
+		Errors:     map[string]string{"HyperVModule": "The module path is not accessible"},
+	})
+
+	report, err := host.preflight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check, _ := report.Check(CheckHyperVModule)
+	if check.Status != CheckFail || !strings.Contains(check.Detail, "The module path is not accessible") {
+		t.Errorf("%s", check)
+	}
+}
+
+func TestPreflightConnectionError(t *testing.T) {
+	host := newPreflightHost(readyFacts)
+	host.responses["FreePhysicalMemory"] = psremote.FakeResponse{Err: errors.New("connection reset")}
+
+	if _, err := host.preflight(); err == nil || errors.Is(err, psremote.ErrRemoteScript) {
+		t.Errorf("error %v, want the failure to run the check", err)
+	}
+}
@@ -0,0 +1,381 @@
+package hvremote
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nimerix/psremote"
+)
+
+//go:embed scripts/*.ps1
+var embeddedScripts embed.FS
+
+// ErrScriptNotFound is returned when a method's script is not in the
+// registry it uses.
+var ErrScriptNotFound = errors.New("script not found")
+
+// ErrInvalidScriptParams is returned when the parameters of a call do not
+// match the script's declared parameters.
+var ErrInvalidScriptParams = errors.New("invalid script parameters")
+
+// ParamType is the type of a script parameter.
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	// ParamInt accepts any Go integer type.
+	ParamInt  ParamType = "int"
+	ParamBool ParamType = "bool"
+	ParamAny  ParamType = "any"
+)
+
+// Param declares a parameter of a Script. Parameter names are matched
+// without regard to case, as in PowerShell.
+type Param struct {
+	Name     string
+	Type     ParamType
+	Optional bool
+}
+
+// Script is a PowerShell script run by HypervRemote. Its parameters are
+// read in the script with $using:name. Optional parameters that are not
+// given are sent as $null, so that every declared parameter can be read.
+//
+// In a .ps1 file the version and parameters are declared by comment lines
+// at the top, before the script itself:
+//
+//	# Version: 2
+//	# Param: vmName string
+//	# Param: generation int optional
+type Script struct {
+	Name    string
+	Version int
+	Params  []Param
+	Body    string
+}
+
+// ParseScript parses the contents of a .ps1 file declaring its version
+// and parameters.
+func ParseScript(name string, data []byte) (*Script, error) {
+	script := &Script{Name: name}
+
+	body := string(data)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := headerLine(line)
+		if !ok {
+			break
+		}
+		if i := strings.Index(body, "\n"); i >= 0 {
+			body = body[i+1:]
+		} else {
+			body = ""
+		}
+
+		switch key {
+		case "version":
+			version, err := strconv.Atoi(value)
+			if err != nil || version < 1 {
+				return nil, fmt.Errorf("script %s: invalid version %q", name, value)
+			}
+			script.Version = version
+		case "param":
+			param, err := parseParam(value)
+			if err != nil {
+				return nil, fmt.Errorf("script %s: %v", name, err)
+			}
+			script.Params = append(script.Params, param)
+		}
+	}
+
+	if script.Version == 0 {
+		return nil, fmt.Errorf("script %s: no version declared", name)
+	}
+
+	script.Body = body
+	return script, nil
+}
+
+// headerLine splits a "# Key: value" line declaring a version or
+// parameter.
+func headerLine(line string) (key, value string, ok bool) {
+	if !strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+
+	line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", "", false
+	}
+
+	key = strings.ToLower(strings.TrimSpace(line[:i]))
+	if key != "version" && key != "param" {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(line[i+1:]), true
+}
+
+func parseParam(value string) (Param, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || len(fields) > 3 || len(fields) == 3 && fields[2] != "optional" {
+		return Param{}, fmt.Errorf("invalid parameter %q, want \"name type [optional]\"", value)
+	}
+
+	param := Param{Name: fields[0], Type: ParamType(fields[1]), Optional: len(fields) == 3}
+	if !param.Type.valid() {
+		return Param{}, fmt.Errorf("parameter %s has unknown type %q", param.Name, param.Type)
+	}
+	return param, nil
+}
+
+func (t ParamType) valid() bool {
+	switch t {
+	case ParamString, ParamInt, ParamBool, ParamAny:
+		return true
+	}
+	return false
+}
+
+// CheckParams reports whether params has every required parameter of s,
+// only parameters it declares, and values of the declared types.
+func (s *Script) CheckParams(params map[string]interface{}) error {
+	declared := make(map[string]Param, len(s.Params))
+	for _, param := range s.Params {
+		declared[strings.ToLower(param.Name)] = param
+	}
+
+	given := make(map[string]bool, len(params))
+	for name, value := range params {
+		param, ok := declared[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("%w: %s does not take %s", ErrInvalidScriptParams, s.Name, name)
+		}
+		if !param.accepts(value) {
+			return fmt.Errorf("%w: %s wants %s as %s, not %T", ErrInvalidScriptParams, s.Name, name, param.Type, value)
+		}
+		given[strings.ToLower(name)] = true
+	}
+
+	for _, param := range s.Params {
+		if !param.Optional && !given[strings.ToLower(param.Name)] {
+			return fmt.Errorf("%w: %s needs %s", ErrInvalidScriptParams, s.Name, param.Name)
+		}
+	}
+	return nil
+}
+
+// withOptional returns params with the optional parameters of s that are
+// not given set to nil. params itself is not changed.
+func (s *Script) withOptional(params map[string]interface{}) map[string]interface{} {
+	given := make(map[string]bool, len(params))
+	for name := range params {
+		given[strings.ToLower(name)] = true
+	}
+
+	all := make(map[string]interface{}, len(s.Params))
+	for name, value := range params {
+		all[name] = value
+	}
+	for _, param := range s.Params {
+		if !given[strings.ToLower(param.Name)] {
+			all[param.Name] = nil
+		}
+	}
+	return all
+}
+
+func (p Param) accepts(value interface{}) bool {
+	if value == nil || p.Type == ParamAny {
+		return true
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.String:
+		return p.Type == ParamString
+	case reflect.Bool:
+		return p.Type == ParamBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return p.Type == ParamInt
+	}
+	return false
+}
+
+// ScriptRegistry holds scripts by name. Names not found in a registry are
+// looked up in its parent, so a registry made with
+// NewScriptRegistry(DefaultScripts) overrides or adds to the built in
+// scripts without changing them for everyone.
+type ScriptRegistry struct {
+	parent *ScriptRegistry
+
+	mu      sync.RWMutex
+	scripts map[string]*Script
+}
+
+// DefaultScripts holds the scripts embedded in this package, one for each
+// HypervRemote method that runs a script, named after the method.
+var DefaultScripts = mustLoadScripts()
+
+func mustLoadScripts() *ScriptRegistry {
+	r := NewScriptRegistry(nil)
+	if err := r.RegisterFS(embeddedScripts, "scripts/*.ps1"); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// NewScriptRegistry returns an empty registry that falls back to parent,
+// which may be nil.
+func NewScriptRegistry(parent *ScriptRegistry) *ScriptRegistry {
+	return &ScriptRegistry{parent: parent, scripts: make(map[string]*Script)}
+}
+
+// Register adds script, replacing any script of the same name in r.
+func (r *ScriptRegistry) Register(script *Script) error {
+	if script.Name == "" {
+		return errors.New("script has no name")
+	}
+	if script.Version < 1 {
+		return fmt.Errorf("script %s: invalid version %d", script.Name, script.Version)
+	}
+	for _, param := range script.Params {
+		if param.Name == "" || !param.Type.valid() {
+			return fmt.Errorf("script %s: invalid parameter %q of type %q", script.Name, param.Name, param.Type)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scripts[script.Name] = script
+	return nil
+}
+
+// RegisterFS parses and registers the .ps1 files of fsys matching pattern,
+// see fs.Glob. Each script is named after its file, without the extension.
+func (r *ScriptRegistry) RegisterFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		script, err := ParseScript(strings.TrimSuffix(path.Base(file), ".ps1"), data)
+		if err != nil {
+			return err
+		}
+		if err := r.Register(script); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the script called name, from r or its parents.
+func (r *ScriptRegistry) Lookup(name string) (*Script, bool) {
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		script, ok := r.scripts[name]
+		r.mu.RUnlock()
+		if ok {
+			return script, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the sorted names of the scripts in r and its parents.
+func (r *ScriptRegistry) Names() []string {
+	seen := make(map[string]bool)
+	for ; r != nil; r = r.parent {
+		r.mu.RLock()
+		for name := range r.scripts {
+			seen[name] = true
+		}
+		r.mu.RUnlock()
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// script returns the body of the script called name and the parameters
+// to send it, after checking params against it.
+func (hvc *HypervRemote) script(name string, params map[string]interface{}) (string, map[string]interface{}, error) {
+	hvc = hvc.configured()
+	registry := hvc.Scripts
+	if registry == nil {
+		registry = DefaultScripts
+	}
+
+	script, ok := registry.Lookup(name)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrScriptNotFound, name)
+	}
+	if err := script.CheckParams(params); err != nil {
+		return "", nil, err
+	}
+	return script.Body, script.withOptional(params), nil
+}
+
+// run runs the script called name on the host.
+func (hvc *HypervRemote) run(ctx context.Context, name string, params map[string]interface{}) (string, error) {
+	hvc = hvc.configured()
+	script, params, err := hvc.script(name, params)
+	if err != nil {
+		return "", err
+	}
+	ctx = psremote.WithScriptName(ctx, name)
+	return hvc.Ps.OutputWinRmContext(ctx, script, params)
+}
+
+// outputJSON runs the script called name on the host and decodes its
+// output into v, see psremote.PSRemote.OutputWinRmJSON.
+func (hvc *HypervRemote) outputJSON(ctx context.Context, name string, params map[string]interface{}, v interface{}) error {
+	hvc = hvc.configured()
+	script, params, err := hvc.script(name, params)
+	if err != nil {
+		return err
+	}
+	ctx = psremote.WithScriptName(ctx, name)
+	return hvc.Ps.OutputWinRmJSONContext(ctx, script, params, v)
+}
+
+// outputString runs a script that returns a single string, or nothing.
+func (hvc *HypervRemote) outputString(ctx context.Context, name string, params map[string]interface{}) (string, error) {
+	var s string
+	err := hvc.outputJSON(ctx, name, params, &s)
+	return s, err
+}
+
+// runSession runs the script called name locally with $Session bound to a
+// PSSession on the host, see psremote.PSRemote.OutputSession.
+func (hvc *HypervRemote) runSession(ctx context.Context, name string, params map[string]interface{}) (string, error) {
+	hvc = hvc.configured()
+	script, params, err := hvc.script(name, params)
+	if err != nil {
+		return "", err
+	}
+	ctx = psremote.WithScriptName(ctx, name)
+	return hvc.Ps.OutputSessionContext(ctx, script, params)
+}
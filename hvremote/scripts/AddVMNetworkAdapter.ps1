@@ -0,0 +1,22 @@
+# Version: 1
+# Param: name string
+# Param: switchName string
+# Param: vlanId string
+# Param: vmId string
+	[string]$name = $using:name
+	[string]$switchName = $using:switchName
+	[string]$vlanId = $using:vlanId
+	[string]$vmId = $using:vmId
+	$VM = Get-VM -Id $vmID
+
+	if(!$VM){Write-Error "Could not get VM: $VM"}
+	$VM | Add-VMNetworkAdapter -Name "$name" -SwitchName "$switchName"
+
+	# Set the boot order to disable pxe
+	$OldBootOrder = Get-VMFirmware -vm $vm | Select-Object -ExpandProperty BootOrder
+	$NewBootOorder = $OldBootOrder  | Where-Object { $_.BootType -ne "Network" }
+	Set-VMFirmware -vm $VM -BootOrder $NewBootOorder
+
+	if(($vlanId -ne $null) -and ($vlanId -ne "")){
+		Set-VMNetworkAdapterVlan -VMNetworkAdapterName "$name" -Access -VlanId $vlanId -VMName $VM.Name
+	}
@@ -0,0 +1,8 @@
+# Version: 1
+# Param: srcPath string
+# Param: vhdDirName string
+	[string]$srcPath = $using:srcPath
+	[string]$vhdDirName = $using:vhdDirName
+Get-ChildItem "$srcPath/$vhdDirName" -Filter *.vhd* | %{
+    Optimize-VHD -Path $_.FullName -Mode Full
+}
@@ -0,0 +1,6 @@
+# Version: 1
+# Param: vmName string
+# Param: switchName string
+	[string]$vmName = $using:vmName
+	[string]$switchName = $using:switchName
+Get-VMNetworkAdapter -VMName $vmName | Connect-VMNetworkAdapter -SwitchName $switchName
@@ -0,0 +1,12 @@
+# Version: 1
+# Param: srcPath string
+# Param: dstPath string
+# Param: vhdDirName string
+# Param: vmDir string
+	[string]$srcPath = $using:srcPath
+	[string]$dstPath = $using:dstPath
+	[string]$vhdDirName = $using:vhdDirName
+	[string]$vmDir = $using:vmDir
+Move-Item -Path $srcPath/*.* -Destination $dstPath
+Move-Item -Path $srcPath/$vhdDirName -Destination $dstPath
+Move-Item -Path $srcPath/$vmDir -Destination $dstPath
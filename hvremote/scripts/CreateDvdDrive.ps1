@@ -0,0 +1,8 @@
+# Version: 1
+# Param: vmName string
+# Param: isoPath string
+$vmName = $using:vmName
+$isoPath = $using:isoPath
+$dvdController = Add-VMDvdDrive -VMName $vmName -path $isoPath -Passthru
+$dvdController | Set-VMDvdDrive -path $null
+$dvdController | Select-Object ControllerNumber, ControllerLocation
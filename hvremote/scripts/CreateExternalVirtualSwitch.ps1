@@ -0,0 +1,28 @@
+# Version: 1
+# Param: vmName string
+# Param: switchName string
+[string]$vmName = $using:vmName
+[string]$switchName = $using:switchName
+$switch = $null
+$names = @('ethernet','wi-fi','lan')
+$adapters = foreach ($name in $names) {
+  Get-NetAdapter -Physical -Name $name -ErrorAction SilentlyContinue | where status -eq 'up'
+}
+
+foreach ($adapter in $adapters) {
+  $switch = Get-VMSwitch -SwitchType External | where { $_.NetAdapterInterfaceDescription -eq $adapter.InterfaceDescription }
+
+  if ($switch -eq $null) {
+    $switch = New-VMSwitch -Name $switchName -NetAdapterName $adapter.Name -AllowManagementOS $true -Notes 'Parent OS, VMs, WiFi'
+  }
+
+  if ($switch -ne $null) {
+    break
+  }
+}
+
+if($switch -ne $null) {
+  Get-VMNetworkAdapter -VMName $vmName | Connect-VMNetworkAdapter -VMSwitch $switch
+} else {
+  Write-Error 'No internet adapters found'
+}
@@ -0,0 +1,11 @@
+# Version: 1
+# Param: vmName string
+# Param: path string
+# Param: ram int
+# Param: switchName string
+[string]$vmName = $using:vmName
+[string]$path = $using:path
+[long]$memoryStartupBytes = $using:ram
+[string]$switchName = $using:switchName
+$VM = New-VM -Name $vmName -Path $path -MemoryStartupBytes $memoryStartupBytes -SwitchName $switchName -BootDevice IDE
+$VM.Id.Guid
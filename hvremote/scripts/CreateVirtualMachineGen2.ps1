@@ -0,0 +1,13 @@
+# Version: 1
+# Param: vmName string
+# Param: path string
+# Param: ram int
+# Param: switchName string
+# Param: generation int
+[string]$vmName = $using:vmName
+[string]$path = $using:path
+[long]$memoryStartupBytes = $using:ram
+[string]$switchName = $using:switchName
+[int]$generation = $using:generation
+$VM = New-VM -Name $vmName -Path $path -MemoryStartupBytes $memoryStartupBytes -Generation $generation -SwitchName $switchName
+$VM.Id.Guid
@@ -0,0 +1,10 @@
+# Version: 1
+# Param: switchName string
+# Param: switchType string
+[string]$switchName = $using:switchName
+[string]$switchType = $using:switchType
+$switches = Get-VMSwitch -Name $switchName -ErrorAction SilentlyContinue
+if ($switches.Count -eq 0) {
+  $SW = New-VMSwitch -Name $switchName -SwitchType $switchType
+  return $SW.Id.guid
+}
@@ -0,0 +1,4 @@
+# Version: 1
+# Param: vmName string
+[string]$vmName = $using:vmName
+Get-VMDvdDrive -VMName $vmName | Remove-VMDvdDrive
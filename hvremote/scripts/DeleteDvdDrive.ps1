@@ -0,0 +1,10 @@
+# Version: 1
+# Param: vmName string
+# Param: controllerNumber int
+# Param: controllerLocation int
+[string]$vmName = $using:vmName
+[int]$controllerNumber = $using:controllerNumber
+[int]$controllerLocation = $using:controllerLocation
+$vmDvdDrive = Get-VMDvdDrive -VMName $vmName -ControllerNumber $controllerNumber -ControllerLocation $controllerLocation
+if (!$vmDvdDrive) {throw 'unable to find dvd drive'}
+Remove-VMDvdDrive -VMName $vmName -ControllerNumber $controllerNumber -ControllerLocation $controllerLocation
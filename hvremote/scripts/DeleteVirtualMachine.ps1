@@ -0,0 +1,13 @@
+# Version: 1
+# Param: vmId string
+[string]$vmId = $using:vmId
+
+$vm = Get-VM -Id $vmId
+
+if (($vm.State -ne [Microsoft.HyperV.PowerShell.VMState]::Off) -and ($vm.State -ne [Microsoft.HyperV.PowerShell.VMState]::OffCritical)) {
+    Stop-VM -VM $vm -TurnOff -Force -Confirm:$false
+}
+
+Remove-VM -VM $vm -Force -Confirm:$false
+Start-Sleep 2
+Remove-Item $VM.ConfigurationLocation -recurse -force
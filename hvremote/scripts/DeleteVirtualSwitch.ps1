@@ -0,0 +1,10 @@
+# Version: 1
+# Param: switchId string
+	[string]$switchId = $using:switchId
+	if (Get-VMSwitch -Id $switchId -ErrorAction SilentlyContinue) {
+		Get-VMSwitch -Id $switchId | Remove-VMSwitch -Force -Confirm:$false -ErrorAction SilentlyContinue
+	}
+
+	if (Get-VMSwitch -Id $switchId -ErrorAction SilentlyContinue) {
+		Write-Error -Category ResourceBusy "Unable to delete switch, ensure that no virtual machines are connected to it"
+	}
@@ -0,0 +1,9 @@
+# Version: 1
+# Param: vmID string
+	[string]$vmID = $using:vmID
+
+	$VM = Get-VM -Id $vmID -ErrorAction SilentlyContinue | select -first 1
+	if(!$VM){Write-Error "Creating VHD for VM ID: $vmID, cannot find VM; return"}
+	$old_boot_order = Get-VMFirmware -VMName $VM.Name | Select-Object -ExpandProperty BootOrder
+	$new_boot_order = $old_boot_order | Where-Object { $_.BootType -ne "Network" }
+	Set-VMFirmware -VMName $VM.Name -BootOrder $new_boot_order
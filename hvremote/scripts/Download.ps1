@@ -0,0 +1,9 @@
+# Version: 1
+# Param: source string
+# Param: dest string
+# Param: hash string optional
+# Param: algorithm string optional
+	$source = $using:source
+	$dest = $using:dest
+
+	(New-Object System.Net.WebClient).DownloadFile($Source, $Dest)
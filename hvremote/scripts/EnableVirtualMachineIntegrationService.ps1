@@ -0,0 +1,6 @@
+# Version: 1
+# Param: vmName string
+# Param: integrationServiceId string
+	[string]$vmName = $using:vmName
+	[string]$integrationServiceId = $using:integrationServiceId
+Get-VMIntegrationService -VmName $vmName | ?{$_.Id -match $integrationServiceId} | Enable-VMIntegrationService
@@ -0,0 +1,9 @@
+# Version: 1
+	$adapters = Get-NetAdapter -Physical -ErrorAction SilentlyContinue | Where-Object { $_.Status -eq 'Up' } | Sort-Object -Descending -Property Speed
+	foreach ($adapter in $adapters) {
+	  $switch = Get-VMSwitch -SwitchType External | Where-Object { $_.NetAdapterInterfaceDescription -eq $adapter.InterfaceDescription }
+	  if ($switch -ne $null) {
+		$switch.Name
+		break
+	  }
+	}
@@ -0,0 +1,4 @@
+# Version: 1
+# Param: source string
+# Param: dest string
+Copy-Item -Path $source -Destination $dest -FromSession $Session
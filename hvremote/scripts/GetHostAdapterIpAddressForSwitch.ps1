@@ -0,0 +1,14 @@
+# Version: 1
+# Param: switchName string
+$switchName = $using:switchName
+$HostVMAdapter = Get-VMNetworkAdapter -ManagementOS -SwitchName $switchName
+if ($HostVMAdapter){
+    $HostNetAdapter = Get-NetAdapter | ?{ $_.DeviceID -eq $HostVMAdapter.DeviceId }
+    if ($HostNetAdapter){
+        $HostNetAdapterConfiguration =  @(get-wmiobject win32_networkadapterconfiguration -filter "IPEnabled = 'TRUE' AND InterfaceIndex=$($HostNetAdapter.ifIndex)")
+        if ($HostNetAdapterConfiguration){
+            return @($HostNetAdapterConfiguration.IpAddress)[0]
+        }
+    }
+}
+return $null
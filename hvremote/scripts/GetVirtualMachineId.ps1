@@ -0,0 +1,9 @@
+# Version: 1
+# Param: vmName string
+[string]$vmName = $using:vmName
+
+$VM = Get-VM -Name $vmName -ErrorAction SilentlyContinue | Select-Object -first 1
+
+if ($VM) {
+	$VM.Id.Guid
+}
@@ -0,0 +1,18 @@
+# Version: 1
+# Param: vmName string
+# Param: adapterName string
+# Param: addressIndex int
+$vmName = $using:vmName
+$adapterName = $using:adapterName
+$addressIndex = $using:addressIndex
+try {
+	Start-Sleep 20
+  $adapter = Get-VMNetworkAdapter -VMName $vmName -Name "$adapterName"
+  $ip = $adapter.IPAddresses[$addressIndex]
+  if($ip -eq $null) {
+    return
+  }
+} catch {
+  return
+}
+$ip
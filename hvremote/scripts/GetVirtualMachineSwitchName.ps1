@@ -0,0 +1,4 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+(Get-VMNetworkAdapter -VMName $vmName).SwitchName
@@ -0,0 +1,16 @@
+# Version: 1
+# Param: Name string optional
+# Param: Id string optional
+[string]$Name = $using:Name
+[string]$Id = $using:Id
+
+if ($Name) {
+	$SW = Get-VMSwitch -Name $Name | Select-Object -first 1
+}
+else {
+	$SW = Get-VMSwitch -Id $Id | Select-Object -first 1
+}
+
+if ($SW) {
+	$SW.Id.Guid
+}
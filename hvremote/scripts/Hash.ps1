@@ -0,0 +1,9 @@
+# Version: 1
+# Param: path string
+# Param: algorithm string
+$path = $using:path
+$algorithm = $using:algorithm
+
+if(!(Test-Path $path)){Write-Error "Cannot find file: $path"}
+
+return (Get-FileHash -Path $Path -Algorith $algorithm).hash
@@ -0,0 +1,15 @@
+# Version: 1
+# Param: mac string
+# Param: adapterIndex int
+	[string]$mac = $using:mac
+	[int]$addressIndex = $using:adapterIndex
+try {
+  $ip = Get-Vm | %{$_.NetworkAdapters} | ?{$_.MacAddress -eq $mac} | %{$_.IpAddresses[$addressIndex]}
+
+  if($ip -eq $null) {
+    return ""
+  }
+} catch {
+  return ""
+}
+$ip
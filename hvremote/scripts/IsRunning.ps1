@@ -0,0 +1,5 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+$vm = Get-VM -Name $vmName -ErrorAction SilentlyContinue
+$vm.State -eq [Microsoft.HyperV.PowerShell.VMState]::Running
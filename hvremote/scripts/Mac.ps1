@@ -0,0 +1,15 @@
+# Version: 1
+# Param: vmName string
+# Param: adapterIndex int
+[string]$vmName = $using:vmName
+$adapterIndex = $using:adapterIndex
+try {
+  $adapter = Get-VMNetworkAdapter -VMName $vmName -ErrorAction SilentlyContinue
+  $mac = $adapter[$adapterIndex].MacAddress
+  if($mac -eq $null) {
+    return ""
+  }
+} catch {
+  return ""
+}
+$mac
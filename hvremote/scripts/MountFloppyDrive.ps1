@@ -0,0 +1,6 @@
+# Version: 1
+# Param: vmName string
+# Param: path string
+[string]$vmName = $using:vmName
+[string]$path = $using:path
+Set-VMFloppyDiskDrive -VMName $vmName -Path $path
@@ -0,0 +1,23 @@
+# Version: 2
+# Param: vhdName string
+# Param: diffParentPath string
+# Param: vmID string
+# Param: vhdDir string
+	[string]$vhdName = $using:vhdName
+	[string]$diffParentPath = $using:diffParentPath
+	[string]$vmID = $using:vmID
+	[string]$vhdDir = $using:vhdDir
+
+	if(Test-Path $diffParentPath){Write-Host "Cannot find Differencing VHD Image: $diffParentPath"}
+
+	$VM = Get-VM -Id $vmID -ErrorAction SilentlyContinue | select -first 1
+	if(!$VM){Write-Error "Creating VHD for VM ID: $vmID, cannot find VM; return"}
+
+	$vhdx = $vhdName + '.vhdx'
+
+	if(!$vhdDir){$vhdDir = $VM.ConfigurationLocation}
+
+	$vhdPath = Join-Path -Path $vhdDir -ChildPath $vhdx
+
+	$VHD = New-VHD -Path $vhdpath -ParentPath $diffParentPath -Differencing
+	Add-VMHardDiskDrive -VM $VM -Path $VHD.Path
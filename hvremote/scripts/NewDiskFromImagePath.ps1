@@ -0,0 +1,21 @@
+# Version: 2
+# Param: vhdName string
+# Param: imagePath string
+# Param: vmID string
+# Param: vhdDir string
+	[string]$vhdName = $using:vhdName
+	[string]$imagePath = $using:imagePath
+	[string]$vmID = $using:vmID
+	[string]$vhdDir = $using:vhdDir
+
+	$VM = Get-VM -Id $vmID -ErrorAction SilentlyContinue | select -first 1
+	if(!$VM){Write-Error "Creating VHD for VM ID: $vmID, cannot find VM; return"}
+
+	$vhdx = $vhdName + '.vhdx'
+	if(!$vhdDir){$vhdDir = $VM.ConfigurationLocation}
+	$vhdPath = Join-Path -Path $vhdDir -ChildPath $vhdx
+
+	if(Test-Path $imagePath){Write-Host "Cannot find VHD Image: $imagePath"}
+	Copy-Item $imagePath $vhdPath
+
+	Add-VMHardDiskDrive -VM $VM -Path $vhdPath
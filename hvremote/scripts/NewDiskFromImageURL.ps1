@@ -0,0 +1,18 @@
+# Version: 2
+# Param: vmID string
+# Param: imageURL string
+# Param: vhdName string
+# Param: vhdDir string
+			[string]$vmID = $using:vmID
+			[string]$vhdDir = $using:vhdDir
+			[string]$imageURL = $using:imageURL
+			[string]$vhdName = $using:vhdName
+			$VM = Get-VM -Id $vmID | select -first 1
+			if(!$VM){Write-Error "Creating VHD for VM ID: $vmID, cannot find VM; return"}
+
+			$vhdx = $vhdName + ".vhdx"
+			if(!$vhdDir){$vhdDir = $VM.ConfigurationLocation}
+			$vhdPath = Join-Path -Path $vhdDir -ChildPath $vhdx
+
+			(New-Object System.Net.WebClient).DownloadFile($imageURL, $vhdPath)
+			Add-VMHardDiskDrive -VM $VM -Path $vhdPath
@@ -0,0 +1,19 @@
+# Version: 2
+# Param: vhdName string
+# Param: diskSize int
+# Param: vmID string
+# Param: vhdDir string
+		[string]$vhdName = $using:vhdName
+		[long]$newVHDSizeBytes = $using:diskSize
+		[string]$vmID = $using:vmID
+		[string]$vhdDir = $using:vhdDir
+
+		$VM = Get-VM -Id $vmID -ErrorAction SilentlyContinue | select -first 1
+		if(!$VM){Write-Error "Creating VHD for VM ID: $vmID, cannot find VM; return"}
+
+		$vhdx = $vhdName + '.vhdx'
+		if(!$vhdDir){$vhdDir = $VM.ConfigurationLocation}
+		$vhdPath = Join-Path -Path $vhdDir -ChildPath $vhdx
+
+		$VHD = New-VHD -Path $vhdPath -SizeBytes $newVHDSizeBytes
+		Add-VMHardDiskDrive -VM $VM -Path $VHD.Path
@@ -0,0 +1,67 @@
+# Version: 2
+# Each fact that cannot be read is left out, and why is kept in Errors
+# under the fact's name.
+$errors = @{}
+$facts = @{ Errors = $errors }
+
+try {
+	$facts.HyperVModule = $null -ne (Get-Module -ListAvailable -Name Hyper-V -ErrorAction Stop)
+} catch {
+	$facts.HyperVModule = $false
+	$errors.HyperVModule = $_.Exception.Message
+}
+
+try {
+	$facts.HypervisorPresent = [bool](Get-CimInstance -ClassName Win32_ComputerSystem -ErrorAction Stop).HypervisorPresent
+} catch {
+	$errors.HypervisorPresent = $_.Exception.Message
+}
+
+try {
+	if (Get-Command Get-WindowsFeature -ErrorAction SilentlyContinue) {
+		$facts.HyperVRole = [string](Get-WindowsFeature -Name Hyper-V -ErrorAction Stop).InstallState
+	} else {
+		$feature = Get-WindowsOptionalFeature -Online -FeatureName Microsoft-Hyper-V-All -ErrorAction Stop
+		if ($feature) {
+			$facts.HyperVRole = [string]$feature.State
+		}
+	}
+} catch {
+	$errors.HyperVRole = $_.Exception.Message
+}
+
+$principal = New-Object System.Security.Principal.WindowsPrincipal([System.Security.Principal.WindowsIdentity]::GetCurrent())
+$facts.HyperVAdministrator = $principal.IsInRole((New-Object System.Security.Principal.SecurityIdentifier 'S-1-5-32-578'))
+
+if ($facts.HyperVModule) {
+	try {
+		$facts.ConfigurationVersions = @(Get-VMHostSupportedVersion -ErrorAction Stop | ForEach-Object { $_.Version.ToString() })
+		$facts.DefaultConfigurationVersion = (Get-VMHostSupportedVersion -Default -ErrorAction Stop).Version.ToString()
+	} catch {
+		$errors.ConfigurationVersions = $_.Exception.Message
+	}
+
+	try {
+		$vmHost = Get-VMHost -ErrorAction Stop
+		$facts.VirtualHardDiskPath = $vmHost.VirtualHardDiskPath
+		$facts.VirtualHardDiskPathExists = Test-Path -Path $vmHost.VirtualHardDiskPath
+		$facts.VirtualMachinePath = $vmHost.VirtualMachinePath
+		$facts.VirtualMachinePathExists = Test-Path -Path $vmHost.VirtualMachinePath
+	} catch {
+		$errors.VMHost = $_.Exception.Message
+	}
+
+	try {
+		$facts.Switches = @(Get-VMSwitch -ErrorAction Stop | ForEach-Object { $_.Name })
+	} catch {
+		$errors.Switches = $_.Exception.Message
+	}
+}
+
+try {
+	$facts.WinRMMaxMemoryPerShellMB = [int](Get-Item -Path WSMan:\localhost\Shell\MaxMemoryPerShellMB -ErrorAction Stop).Value
+} catch {
+	$errors.WinRM = $_.Exception.Message
+}
+
+$facts
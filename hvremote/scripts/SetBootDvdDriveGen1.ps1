@@ -0,0 +1,4 @@
+# Version: 1
+# Param: vmName string
+$vmName = $using:vmName
+Set-VMBios -VMName $vmName -StartupOrder @("CD", "IDE","LegacyNetworkAdapter","Floppy")
@@ -0,0 +1,8 @@
+# Version: 1
+# Param: vmName string
+# Param: adapterName string
+# Param: mac string
+		[string]$vmName = $using:vmName
+		[string]$adapterName = $using:adapterName
+		[string]$mac = $using:mac
+		Set-VMNetworkAdapter -VmName $vmName -VMNetworkAdapterName $adapterName -StaticMacAddress $mac
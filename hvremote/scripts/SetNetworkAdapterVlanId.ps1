@@ -0,0 +1,6 @@
+# Version: 1
+# Param: networkAdapterName string
+# Param: vlanId string
+	[string]$networkAdapterName = $using:networkAdapterName
+	[string]$vlanId = $using:vlanId
+Set-VMNetworkAdapterVlan -ManagementOS -VMNetworkAdapterName $networkAdapterName -Access -VlanId $vlanId
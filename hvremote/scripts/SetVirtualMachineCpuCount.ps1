@@ -0,0 +1,8 @@
+# Version: 1
+# Param: vmId string
+# Param: cpu int
+	[string]$vmId = $using:vmId
+	[int]$cpu = $using:cpu
+
+$VM = Get-Vm -Id $vmId
+Set-VMProcessor -VM $VM -Count $cpu
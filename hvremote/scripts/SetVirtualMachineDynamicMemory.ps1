@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+# Param: enableDynamicMemoryString string
+	[string]$vmName = $using:vmName
+	[string]$enableDynamicMemoryString = $using:enableDynamicMemoryString
+$enableDynamicMemory = [System.Boolean]::Parse($enableDynamicMemoryString)
+Set-VMMemory -VMName $vmName -DynamicMemoryEnabled $enableDynamicMemory
@@ -0,0 +1,6 @@
+# Version: 1
+# Param: vmName string
+# Param: enableMacSpoofing string
+	[string]$vmName = $using:vmName
+	$enableMacSpoofing = $using:enableMacSpoofing
+Set-VMNetworkAdapter -VMName $vmName -MacAddressSpoofing $enableMacSpoofing
@@ -0,0 +1,6 @@
+# Version: 1
+# Param: vmName string
+# Param: enableSecureBoot string
+	[string]$vmName = $using:vmName
+	$enableSecureBoot = $using:enableSecureBoot
+Set-VMFirmware -VMName $vmName -EnableSecureBoot $enableSecureBoot
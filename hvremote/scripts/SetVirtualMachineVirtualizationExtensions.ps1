@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+# Param: exposeVirtualizationExtensionsString string
+	[string]$vmName = $using:vmName
+	[string]$exposeVirtualizationExtensionsString = $using:exposeVirtualizationExtensionsString
+$exposeVirtualizationExtensions = [System.Boolean]::Parse($exposeVirtualizationExtensionsString)
+Set-VMProcessor -VMName $vmName -ExposeVirtualizationExtensions $exposeVirtualizationExtensions
@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmID string
+# Param: vlanId string
+[string]$vmID = $using:vmID
+[string]$vlanId = $using:vlanId
+$VM = Get-VM -Id $vmID
+Set-VMNetworkAdapterVlan -VMName $VM.Name -Access -VlanId $vlanId
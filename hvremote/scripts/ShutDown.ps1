@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+$vm = Get-VM -Name $vmName -ErrorAction SilentlyContinue
+if ($vm.State -eq [Microsoft.HyperV.PowerShell.VMState]::Running) {
+  Stop-VM -Name $vmName -Force -Confirm:$false
+}
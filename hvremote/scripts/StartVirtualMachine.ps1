@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+$vm = Get-VM -Name $vmName -ErrorAction SilentlyContinue
+if ($vm.State -eq [Microsoft.HyperV.PowerShell.VMState]::Off) {
+  Start-VM -Name $vmName -Confirm:$false
+}
@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+$vm = Get-VM -Name $vmName
+if ($vm.State -eq [Microsoft.HyperV.PowerShell.VMState]::Running) {
+    Stop-VM -VM $vm -Force -Confirm:$false
+}
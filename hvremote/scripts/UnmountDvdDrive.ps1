@@ -0,0 +1,11 @@
+# Version: 1
+# Param: vmName string
+# Param: controllerNumber int
+# Param: controllerLocation int
+$vmName = $using:vmName
+$controllerNumber = $using:controllerNumber
+$controllerLocation = $using:controllerLocation
+
+$vmDvdDrive = Get-VMDvdDrive -VMName $vmName -ControllerNumber $controllerNumber -ControllerLocation $controllerLocation
+if (!$vmDvdDrive) {throw 'unable to find dvd drive'}
+Set-VMDvdDrive -VMName $vmName -ControllerNumber $controllerNumber -ControllerLocation $controllerLocation -Path $null
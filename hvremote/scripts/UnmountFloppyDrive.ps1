@@ -0,0 +1,4 @@
+# Version: 1
+# Param: vmName string
+	[string]$vmName = $using:vmName
+	Set-VMFloppyDiskDrive -VMName $vmName -Path $null
@@ -0,0 +1,7 @@
+# Version: 1
+# Param: vmName string
+# Param: switchName string
+	[string]$vmName = $using:vmName
+	[string]$switchName = $using:switchName
+Set-VMNetworkAdapterVlan -VMName $vmName -Untagged
+Set-VMNetworkAdapterVlan -ManagementOS -VMNetworkAdapterName $switchName -Untagged
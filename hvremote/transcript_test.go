@@ -0,0 +1,125 @@
+package hvremote
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/nimerix/psremote"
+)
+
+var update = flag.Bool("update", false, "rewrite the transcripts in testdata")
+
+// transcriptFixture is synthetic: it is recorded with -update against
+// syntheticHost, not against a real Hyper-V host. It pins the scripts and
+// parameters each method sends; the outputs are made up.
+const transcriptFixture = "testdata/synthetic_transcript.json"
+
+// syntheticResponses are the answers of syntheticHost, by script name.
+var syntheticResponses = map[string]psremote.FakeResponse{
+	"GetVirtualMachineId": {Stdout: `"5f3b7c4e-2a1d-4e8f-9b6a-0c1d2e3f4a5b"` + "\n"},
+	"IsRunning":           {Stdout: "false\n"},
+	"Uptime":              {Stdout: "0\n"},
+	"Mac":                 {Stdout: `"00155D010203"` + "\n"},
+	"CreateDvdDrive":      {Stdout: `{"ControllerNumber":1,"ControllerLocation":0}` + "\n"},
+	"StartVirtualMachine": {Err: &psremote.ExitError{Code: 1}},
+}
+
+// syntheticHost stands in for hyperv01 when the fixture is recorded,
+// telling the scripts apart by their bodies.
+func syntheticHost(cmd *psremote.Command) psremote.FakeResponse {
+	for name, resp := range syntheticResponses {
+		script, _ := DefaultScripts.Lookup(name)
+		if strings.Contains(cmd.Script, script.Body) {
+			return resp
+		}
+	}
+	return psremote.FakeResponse{Err: &psremote.ExitError{Code: 1}}
+}
+
+func newTranscriptRemote(executor psremote.Executor) *HypervRemote {
+	return &HypervRemote{Ps: &psremote.PSRemote{
+		ComputerName:   "hyperv01",
+		UserName:       "admin",
+		Password:       "secret",
+		PowerShellPath: "pwsh",
+		Executor:       executor,
+	}}
+}
+
+// runTranscriptCalls makes the calls recorded in the fixture.
+func runTranscriptCalls(t *testing.T, hvc *HypervRemote) {
+	id, err := hvc.GetVirtualMachineId(map[string]interface{}{"vmName": "web"})
+	if err != nil || id != "5f3b7c4e-2a1d-4e8f-9b6a-0c1d2e3f4a5b" {
+		t.Errorf("GetVirtualMachineId = %q, %v", id, err)
+	}
+
+	if running, err := hvc.IsRunning("web"); err != nil || running {
+		t.Errorf("IsRunning = %v, %v", running, err)
+	}
+	if uptime, err := hvc.Uptime("web"); err != nil || uptime != 0 {
+		t.Errorf("Uptime = %d, %v", uptime, err)
+	}
+	if mac, err := hvc.Mac("web"); err != nil || mac != "00155D010203" {
+		t.Errorf("Mac = %q, %v", mac, err)
+	}
+
+	number, location, err := hvc.CreateDvdDrive("web", `D:\iso\setup.iso`, 2)
+	if err != nil || number != 1 || location != 0 {
+		t.Errorf("CreateDvdDrive = %d, %d, %v", number, location, err)
+	}
+
+	err = hvc.StartVirtualMachine("web")
+	var scriptErr *psremote.ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.Script != "StartVirtualMachine" || scriptErr.ExitCode != 1 {
+		t.Errorf("StartVirtualMachine error %v, want a *ScriptError naming the script", err)
+	}
+}
+
+func TestTranscriptReplay(t *testing.T) {
+	if *update {
+		hvc := newTranscriptRemote(&psremote.FakeExecutor{Handler: syntheticHost})
+		hvc.Ps.Recorder = psremote.NewRecorder(transcriptFixture)
+		runTranscriptCalls(t, hvc)
+	}
+
+	transcript, err := psremote.LoadTranscript(transcriptFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := psremote.NewReplayExecutor(transcript)
+	replay.Ordered = true
+	runTranscriptCalls(t, newTranscriptRemote(replay))
+
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("%d entries were not replayed", len(unused))
+	}
+}
+
+// TestTranscriptReplayChangedScript checks that the fixture catches a
+// script that no longer matches what was recorded.
+func TestTranscriptReplayChangedScript(t *testing.T) {
+	transcript, err := psremote.LoadTranscript(transcriptFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scripts := NewScriptRegistry(DefaultScripts)
+	isRunning, _ := DefaultScripts.Lookup("IsRunning")
+	changed := *isRunning
+	changed.Body += "\n$vm.State"
+	if err := scripts.Register(&changed); err != nil {
+		t.Fatal(err)
+	}
+
+	hvc := newTranscriptRemote(psremote.NewReplayExecutor(transcript))
+	hvc.Scripts = scripts
+	if _, err := hvc.IsRunning("web"); !errors.Is(err, psremote.ErrUnexpectedCommand) {
+		t.Errorf("error %v, want ErrUnexpectedCommand", err)
+	}
+	if _, err := hvc.IsRunning("db"); !errors.Is(err, psremote.ErrUnexpectedCommand) {
+		t.Errorf("error %v for another virtual machine, want ErrUnexpectedCommand", err)
+	}
+}
@@ -0,0 +1,89 @@
+package psremote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultJSONDepth is the ConvertTo-Json depth used when
+// PSRemote.JSONDepth is zero.
+const DefaultJSONDepth = 4
+
+// OutputJSON runs script, converts whatever it returns with ConvertTo-Json
+// and unmarshals the result into out. A script that returns nothing
+// leaves out untouched. When out points to a slice a single returned
+// object is decoded as a one element slice.
+func (ps *PSRemote) OutputJSON(script string, params map[string]interface{}, out interface{}) error {
+	return ps.OutputJSONContext(context.Background(), script, params, out)
+}
+
+// OutputJSONContext is like OutputJSON but stops PowerShell when ctx is done.
+func (ps *PSRemote) OutputJSONContext(ctx context.Context, script string, params map[string]interface{}, out interface{}) error {
+	ps = ps.configured()
+	cmdOut, err := ps.OutputContext(ctx, ps.wrapJSON(script), params)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(cmdOut, out)
+}
+
+// OutputWinRmJSON is the remote counterpart of OutputJSON. The conversion
+// happens on the remote host, so no type information is lost to remoting
+// serialisation.
+func (ps *PSRemote) OutputWinRmJSON(scriptBlock string, params map[string]interface{}, out interface{}) error {
+	return ps.OutputWinRmJSONContext(context.Background(), scriptBlock, params, out)
+}
+
+// OutputWinRmJSONContext is like OutputWinRmJSON but stops the remote call
+// when ctx is done.
+func (ps *PSRemote) OutputWinRmJSONContext(ctx context.Context, scriptBlock string, params map[string]interface{}, out interface{}) error {
+	ps = ps.configured()
+	cmdOut, err := ps.OutputWinRmContext(ctx, ps.wrapJSON(scriptBlock), params)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(cmdOut, out)
+}
+
+func (ps *PSRemote) wrapJSON(script string) string {
+	depth := ps.JSONDepth
+	if depth <= 0 {
+		depth = DefaultJSONDepth
+	}
+
+	// The script runs in its own scope so that a top level return still
+	// reaches ConvertTo-Json.
+	return `
+$psremoteResult = & {
+` + script + `
+}
+ConvertTo-Json -InputObject $psremoteResult -Depth ` + strconv.Itoa(depth) + ` -Compress
+`
+}
+
+func decodeJSON(cmdOut string, out interface{}) error {
+	// Anything the script wrote to the host precedes the compressed
+	// document on the last line.
+	data := strings.TrimSpace(cmdOut)
+	if i := strings.LastIndexAny(data, "\r\n"); i >= 0 {
+		data = strings.TrimSpace(data[i+1:])
+	}
+
+	if data == "" || data == "null" {
+		return nil
+	}
+
+	if t := reflect.TypeOf(out); t != nil && t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Slice && data[0] != '[' {
+		data = "[" + data + "]"
+	}
+
+	if err := json.Unmarshal([]byte(data), out); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidJSON, err)
+	}
+
+	return nil
+}
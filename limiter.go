@@ -0,0 +1,68 @@
+package psremote
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// HostLimiter limits how many calls run at once against each host, such
+// as to stay below the WinRM MaxConcurrentOperationsPerUser and
+// MaxShellsPerUser quotas when many resources are managed in parallel.
+// One HostLimiter can be shared by every PSRemote talking to the same
+// hosts.
+type HostLimiter struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// NewHostLimiter returns a HostLimiter allowing limit calls at once per
+// host. A limit of zero or less allows any number.
+func NewHostLimiter(limit int) *HostLimiter {
+	return &HostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a free slot for host, and returns the function that
+// frees it.
+func (l *HostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	if l == nil || l.limit <= 0 {
+		return func() {}, nil
+	}
+
+	// Host names are not case sensitive.
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[host] = slots
+	}
+	l.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, &CanceledError{Err: ctx.Err()}
+	}
+}
+
+// limited returns call made to wait for Limiter.
+func (ps *PSRemote) limited(call func(ctx context.Context) (*Result, error)) func(ctx context.Context) (*Result, error) {
+	if ps.Limiter == nil {
+		return call
+	}
+
+	return func(ctx context.Context) (*Result, error) {
+		release, err := ps.Limiter.acquire(ctx, ps.ComputerName)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		return call(ctx)
+	}
+}
@@ -0,0 +1,166 @@
+package psremote
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives PSRemote's log records as a message followed by
+// alternating keys and values. *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+}
+
+// LogLevel selects how much PSRemote logs.
+type LogLevel int
+
+const (
+	// LogQuiet logs nothing. This is the default.
+	LogQuiet LogLevel = iota
+	// LogVerbose logs each command run and its output at Info.
+	LogVerbose
+	// LogDebug also logs each generated script at Debug. Temporary
+	// script files are still removed; the log holds their contents.
+	LogDebug
+)
+
+const redacted = "<redacted>"
+
+// AddSecret registers values to be redacted from everything PSRemote
+// logs. Password, and every password supplied by Credentials, are
+// registered automatically.
+func (ps *PSRemote) AddSecret(secrets ...string) {
+	ps = ps.configured()
+
+	ps.secretsMu.Lock()
+	defer ps.secretsMu.Unlock()
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		known := false
+		for _, s := range ps.secrets {
+			if s == secret {
+				known = true
+				break
+			}
+		}
+		if !known {
+			ps.secrets = append(ps.secrets, secret)
+		}
+	}
+}
+
+// redact replaces every registered secret in s.
+func (ps *PSRemote) redact(s string) string {
+	ps.secretsMu.Lock()
+	defer ps.secretsMu.Unlock()
+
+	if ps.Password != "" {
+		s = strings.Replace(s, ps.Password, redacted, -1)
+	}
+	for _, secret := range ps.secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	return s
+}
+
+func (ps *PSRemote) logger() Logger {
+	if ps.Logger != nil {
+		return ps.Logger
+	}
+	return stdLogger{}
+}
+
+func (ps *PSRemote) verbose() bool {
+	return ps.LogLevel >= LogVerbose
+}
+
+func (ps *PSRemote) debug() bool {
+	return ps.LogLevel >= LogDebug
+}
+
+// logVerbose logs msg at Info, with every string value redacted.
+func (ps *PSRemote) logVerbose(msg string, args ...interface{}) {
+	if ps.verbose() {
+		ps.logger().Info(msg, ps.redactArgs(args)...)
+	}
+}
+
+// logDebug logs msg at Debug, with every string value redacted.
+func (ps *PSRemote) logDebug(msg string, args ...interface{}) {
+	if ps.debug() {
+		ps.logger().Debug(msg, ps.redactArgs(args)...)
+	}
+}
+
+func (ps *PSRemote) redactArgs(args []interface{}) []interface{} {
+	redactedArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			redactedArgs[i] = ps.redact(v)
+		case []string:
+			values := make([]string, len(v))
+			for j, s := range v {
+				values[j] = ps.redact(s)
+			}
+			redactedArgs[i] = values
+		case map[string]interface{}:
+			redactedArgs[i] = ps.redact(fmt.Sprint(v))
+		case error:
+			redactedArgs[i] = ps.redact(v.Error())
+		default:
+			redactedArgs[i] = arg
+		}
+	}
+	return redactedArgs
+}
+
+// commandArgs returns cmd's arguments for logging. Encoded parameters and
+// commands are replaced, since redaction cannot see through them.
+func commandArgs(cmd *Command) []string {
+	serialized, _ := serializeParams(cmd.Params)
+
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		switch {
+		case serialized != "" && arg == serialized:
+			arg = "<params>"
+		case i > 0 && strings.EqualFold(cmd.Args[i-1], "-EncodedCommand"):
+			arg = "<encoded command>"
+		}
+		args[i] = arg
+	}
+	return args
+}
+
+// stdLogger writes to the standard log package, which is used when Logger
+// is not set.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...interface{}) {
+	log.Print(formatLog("DEBUG", msg, args))
+}
+
+func (stdLogger) Info(msg string, args ...interface{}) {
+	log.Print(formatLog("INFO", msg, args))
+}
+
+func formatLog(level, msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%q", args[i], fmt.Sprint(args[i+1]))
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	return b.String()
+}
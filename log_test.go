@@ -0,0 +1,138 @@
+package psremote
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// captureLogger keeps every record it is given, formatted.
+type captureLogger struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (l *captureLogger) Debug(msg string, args ...interface{}) {
+	l.add("DEBUG", msg, args)
+}
+
+func (l *captureLogger) Info(msg string, args ...interface{}) {
+	l.add("INFO", msg, args)
+}
+
+func (l *captureLogger) add(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, formatLog(level, msg, args))
+}
+
+func TestLogRedaction(t *testing.T) {
+	params := map[string]interface{}{"token": "token-123", "name": "web"}
+	serialized, _ := serializeParams(params)
+
+	credentials := map[string]func(ps *PSRemote) string{
+		"password": func(ps *PSRemote) string {
+			ps.UserName, ps.Password = "admin", "hunter2"
+			return "hunter2"
+		},
+		"provider": func(ps *PSRemote) string {
+			ps.Credentials = StaticCredentials{UserName: "admin", Password: "provider-pw"}
+			return "provider-pw"
+		},
+	}
+
+	for _, level := range []LogLevel{LogQuiet, LogVerbose, LogDebug} {
+		for _, mode := range []ScriptMode{ScriptFile, ScriptEncodedCommand, ScriptStdin} {
+			for name, setCredential := range credentials {
+				logger := &captureLogger{}
+				var secrets []string
+				// The host echoes every secret back.
+				fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+					return FakeResponse{
+						Stdout: strings.Join(secrets, " ") + "\n",
+						Stderr: "WARNING: " + strings.Join(secrets, " ") + "\n",
+					}
+				}}
+				ps := &PSRemote{
+					ComputerName:   "host",
+					PowerShellPath: "pwsh",
+					ScriptMode:     mode,
+					Executor:       fake,
+					Logger:         logger,
+					LogLevel:       level,
+				}
+				secrets = []string{setCredential(ps), "token-123"}
+				ps.AddSecret("token-123")
+
+				if _, err := ps.OutputWinRm("Write-Output $using:token", params); err != nil {
+					t.Fatal(err)
+				}
+
+				desc := fmt.Sprintf("level %d, mode %d, %s", level, mode, name)
+				if (level == LogQuiet) != (len(logger.records) == 0) {
+					t.Fatalf("%s: %d records logged", desc, len(logger.records))
+				}
+				if level == LogDebug && !strings.HasPrefix(logger.records[0], "DEBUG PowerShell script") {
+					t.Errorf("%s: script not logged first, got %s", desc, logger.records[0])
+				}
+
+				for _, record := range logger.records {
+					for _, secret := range secrets {
+						if strings.Contains(record, secret) || strings.Contains(record, base64.StdEncoding.EncodeToString([]byte(secret))) {
+							t.Errorf("%s: %s reached the log: %s", desc, secret, record)
+						}
+					}
+					if strings.Contains(record, serialized) {
+						t.Errorf("%s: serialized parameters reached the log: %s", desc, record)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	ps := (&PSRemote{Password: "hunter2"}).configured()
+	ps.AddSecret("token-123", "")
+
+	got := ps.redactArgs([]interface{}{
+		"key", "pw=hunter2",
+		"list", []string{"a", "token-123"},
+		"params", map[string]interface{}{"p": "hunter2"},
+		"error", fmt.Errorf("login as hunter2 failed"),
+		"attempt", 2,
+	})
+	want := []interface{}{
+		"key", "pw=<redacted>",
+		"list", []string{"a", "<redacted>"},
+		"params", "map[p:<redacted>]",
+		"error", "login as <redacted> failed",
+		"attempt", 2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactArgs = %v, want %v", got, want)
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	params := map[string]interface{}{"password": "hunter2"}
+	serialized, _ := serializeParams(params)
+
+	cmd := &Command{
+		Args:   []string{"-NoProfile", "-EncodedCommand", encodeCommand("Get-VM"), "-File", "script.ps1", serialized},
+		Params: params,
+	}
+	want := []string{"-NoProfile", "-EncodedCommand", "<encoded command>", "-File", "script.ps1", "<params>"}
+	if got := commandArgs(cmd); !reflect.DeepEqual(got, want) {
+		t.Errorf("commandArgs = %q, want %q", got, want)
+	}
+
+	// The command itself is left alone.
+	if cmd.Args[5] != serialized {
+		t.Error("commandArgs changed the command")
+	}
+}
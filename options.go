@@ -0,0 +1,207 @@
+package psremote
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication is a WinRM authentication mechanism, as taken by the
+// -Authentication parameter of Invoke-Command.
+type Authentication string
+
+const (
+	AuthenticationDefault   Authentication = "Default"
+	AuthenticationNegotiate Authentication = "Negotiate"
+	AuthenticationKerberos  Authentication = "Kerberos"
+	AuthenticationCredSSP   Authentication = "CredSSP"
+	AuthenticationBasic     Authentication = "Basic"
+	// AuthenticationDigest is only valid as ProxyAuthentication.
+	AuthenticationDigest Authentication = "Digest"
+)
+
+// ProxyAccessType selects how the proxy server is found, as taken by
+// New-PSSessionOption -ProxyAccessType.
+type ProxyAccessType string
+
+const (
+	ProxyIEConfig      ProxyAccessType = "IEConfig"
+	ProxyWinHttpConfig ProxyAccessType = "WinHttpConfig"
+	ProxyAutoDetect    ProxyAccessType = "AutoDetect"
+	ProxyNone          ProxyAccessType = "NoProxyServer"
+)
+
+// SessionOptions are the New-PSSessionOption settings used to reach
+// ComputerName. The zero value leaves PowerShell's defaults.
+type SessionOptions struct {
+	// SkipCACheck and SkipCNCheck accept a certificate from an unknown
+	// authority, or issued for another name, with UseSSL. Use them only
+	// on trusted networks, such as for lab hosts with self-signed
+	// certificates.
+	SkipCACheck bool
+	SkipCNCheck bool
+	// OperationTimeout limits each WinRM operation. Zero leaves the
+	// transport's default: three minutes for TransportPowerShell, which
+	// is New-PSSessionOption's, and 60 seconds for TransportWinRM.
+	OperationTimeout time.Duration
+	// ProxyAccessType and ProxyAuthentication configure the proxy, which
+	// authenticates as the local user.
+	ProxyAccessType     ProxyAccessType
+	ProxyAuthentication Authentication
+}
+
+func (o SessionOptions) isZero() bool {
+	return o == SessionOptions{}
+}
+
+// remotingScript sets $psremoteArgs to the parameters of Invoke-Command and
+// New-PSSession for ComputerName. It expects $psremoteCredential to hold
+// the credential read by Read-PSRemoteCredential.
+func (ps *PSRemote) remotingScript(ctx context.Context) (string, error) {
+	if ps.Transport == TransportSSH {
+		// Only the user name of the credential is used, as ssh
+		// authenticates with keys.
+		credential, err := ps.credential(ctx)
+		if err != nil {
+			return "", err
+		}
+		return ps.sshRemotingScript(credential.UserName), nil
+	}
+
+	var b strings.Builder
+
+	b.WriteString("$psremoteArgs = @{ ComputerName = " + quote(ps.ComputerName) + " }\n")
+	b.WriteString("if ($psremoteCredential) { $psremoteArgs.Credential = $psremoteCredential }\n")
+
+	if ps.UseSSL {
+		b.WriteString("$psremoteArgs.UseSSL = $true\n")
+	}
+	if ps.Port != 0 {
+		b.WriteString("$psremoteArgs.Port = " + strconv.Itoa(ps.Port) + "\n")
+	}
+	if ps.Authentication != "" {
+		b.WriteString("$psremoteArgs.Authentication = " + quote(string(ps.Authentication)) + "\n")
+	}
+	if ps.ConfigurationName != "" {
+		b.WriteString("$psremoteArgs.ConfigurationName = " + quote(ps.ConfigurationName) + "\n")
+	}
+	if ps.ApplicationName != "" {
+		b.WriteString("$psremoteArgs.ApplicationName = " + quote(ps.ApplicationName) + "\n")
+	}
+
+	options := ps.SessionOptions
+	if !options.isZero() {
+		b.WriteString("$psremoteArgs.SessionOption = New-PSSessionOption")
+		if options.SkipCACheck {
+			b.WriteString(" -SkipCACheck")
+		}
+		if options.SkipCNCheck {
+			b.WriteString(" -SkipCNCheck")
+		}
+		if options.OperationTimeout > 0 {
+			b.WriteString(" -OperationTimeout " + strconv.FormatInt(int64(options.OperationTimeout/time.Millisecond), 10))
+		}
+		if options.ProxyAccessType != "" {
+			b.WriteString(" -ProxyAccessType " + quote(string(options.ProxyAccessType)))
+		}
+		if options.ProxyAuthentication != "" {
+			b.WriteString(" -ProxyAuthentication " + quote(string(options.ProxyAuthentication)))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String(), nil
+}
+
+// sshRemotingScript sets $psremoteArgs for remoting over SSH as userName.
+func (ps *PSRemote) sshRemotingScript(userName string) string {
+	var b strings.Builder
+
+	b.WriteString("$psremoteArgs = @{ HostName = " + quote(ps.ComputerName) + " }\n")
+	if userName != "" {
+		b.WriteString("$psremoteArgs.UserName = " + quote(userName) + "\n")
+	}
+	if ps.Port != 0 {
+		b.WriteString("$psremoteArgs.Port = " + strconv.Itoa(ps.Port) + "\n")
+	}
+	if ps.KeyFilePath != "" {
+		b.WriteString("$psremoteArgs.KeyFilePath = " + quote(ps.KeyFilePath) + "\n")
+	}
+
+	return b.String()
+}
+
+// quote returns s as a single quoted PowerShell string.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// checkNativeOptions reports the options TransportWinRM cannot honour. It
+// only speaks Basic authentication to the default shell. Default is
+// Negotiate to PowerShell, so it is refused too.
+func (ps *PSRemote) checkNativeOptions() error {
+	switch ps.Authentication {
+	case "", AuthenticationBasic:
+	default:
+		return fmt.Errorf("%w: TransportWinRM does not support %s authentication, use TransportPowerShell", ErrUnsupportedOption, ps.Authentication)
+	}
+
+	if ps.ConfigurationName != "" {
+		return fmt.Errorf("%w: TransportWinRM does not support ConfigurationName, use TransportPowerShell", ErrUnsupportedOption)
+	}
+	if ps.SessionOptions.ProxyAuthentication != "" {
+		return fmt.Errorf("%w: TransportWinRM does not support ProxyAuthentication, use TransportPowerShell", ErrUnsupportedOption)
+	}
+
+	return nil
+}
+
+// nativeHTTPClient returns HTTPClient, or a client applying the certificate
+// and proxy options when it is nil.
+func (ps *PSRemote) nativeHTTPClient() *http.Client {
+	options := ps.SessionOptions
+	if ps.HTTPClient != nil || !options.SkipCACheck && !options.SkipCNCheck && options.ProxyAccessType != ProxyNone {
+		return ps.HTTPClient
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.ProxyAccessType == ProxyNone {
+		transport.Proxy = nil
+	}
+
+	switch {
+	case options.SkipCACheck:
+		// Without a trusted chain there is nothing to check the name
+		// against either.
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	case options.SkipCNCheck:
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection:   verifyChainOnly,
+		}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// verifyChainOnly checks the server certificate chain but not the name it
+// was issued for.
+func verifyChainOnly(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server sent no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Intermediates: intermediates})
+	return err
+}
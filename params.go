@@ -0,0 +1,118 @@
+package psremote
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// paramPreamble decodes the parameters passed by scriptCommand and sets
+// each one as a variable. The parameters arrive as base64 encoded JSON in
+// which every string is itself base64 encoded behind an "s:" tag, so that
+// no value can be mangled by quoting or reinterpreted by ConvertFrom-Json,
+// which turns date-like strings into DateTime on PowerShell 7. Maps become
+// hashtables and lists become arrays.
+const paramPreamble = `param([string]$paramsString)
+function ConvertFrom-PSRemoteParam($value) {
+	if ($value -is [string]) {
+		return [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($value.Substring(2)))
+	}
+	if ($value -is [System.Management.Automation.PSCustomObject]) {
+		$table = @{}
+		foreach ($property in $value.PSObject.Properties) {
+			$table[$property.Name] = ConvertFrom-PSRemoteParam $property.Value
+		}
+		return $table
+	}
+	if ($value -is [array]) {
+		return ,@($value | ForEach-Object { ConvertFrom-PSRemoteParam $_ })
+	}
+	return $value
+}
+if ($paramsString) {
+	$params = [System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($paramsString)) | ConvertFrom-Json
+	foreach ($param in $params.PSObject.Properties) {
+		Set-Variable -Name $param.Name -Value (ConvertFrom-PSRemoteParam $param.Value)
+	}
+}
+`
+
+// serializeParams encodes params for paramPreamble. Values may be strings,
+// booleans, numbers, slices or arrays of those, maps with string keys, or
+// pointers to any of them.
+func serializeParams(params map[string]interface{}) (string, error) {
+	if len(params) == 0 {
+		return "", nil
+	}
+
+	encoded, err := encodeParamValue(reflect.ValueOf(params))
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func encodeParamValue(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeParamValue(v.Elem())
+	case reflect.String:
+		return "s:" + base64.StdEncoding.EncodeToString([]byte(v.String())), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			item, err := encodeParamValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported parameter type %s: map keys must be strings", v.Type())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		table := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			item, err := encodeParamValue(v.MapIndex(key))
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: %s", key.String(), err)
+			}
+			table[key.String()] = item
+		}
+		return table, nil
+	}
+
+	return nil, fmt.Errorf("unsupported parameter type %s", v.Type())
+}
@@ -0,0 +1,216 @@
+package psremote
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+var paramCases = []struct {
+	name  string
+	value interface{}
+	// want is the value as ConvertTo-Json would print it once decoded.
+	want string
+}{
+	{"string", "hello", `"hello"`},
+	{"empty string", "", `""`},
+	{"quotes", `it's "quoted"`, `"it's \"quoted\""`},
+	{"newlines", "a\nb\r\nc", `"a\nb\r\nc"`},
+	{"metacharacters", "a=b\\c`$d;$(e) @{f}", `"a=b\\c` + "`" + `$d;$(e) @{f}"`},
+	{"unicode", "héllo wörld ✓ 日本語 🚀", `"héllo wörld ✓ 日本語 🚀"`},
+	{"date-like string", "2024-01-02T03:04:05Z", `"2024-01-02T03:04:05Z"`},
+	{"int", 42, `42`},
+	{"negative int", int8(-7), `-7`},
+	{"max int64", int64(math.MaxInt64), `9223372036854775807`},
+	{"uint", uint16(65535), `65535`},
+	{"float", 1.5, `1.5`},
+	{"true", true, `true`},
+	{"false", false, `false`},
+	{"nil", nil, `null`},
+	{"nil slice", []string(nil), `null`},
+	{"nil map", map[string]string(nil), `null`},
+	{"nil pointer", (*string)(nil), `null`},
+	{"pointer", func() *string { s := "pointed"; return &s }(), `"pointed"`},
+	{"string slice", []string{"a", "b c"}, `["a","b c"]`},
+	{"single element slice", []string{"only"}, `["only"]`},
+	{"mixed slice", []interface{}{1, "x", true, nil}, `[1,"x",true,null]`},
+	{"array", [2]int{1, 2}, `[1,2]`},
+	{"nested map", map[string]interface{}{
+		"a": map[string]interface{}{"b": []int{1, 2}, "c": "d\ne"},
+		"f": []map[string]bool{{"g": true}},
+	}, `{"a":{"b":[1,2],"c":"d\ne"},"f":[{"g":true}]}`},
+}
+
+// decodeParam mirrors ConvertFrom-PSRemoteParam in paramPreamble, applied
+// to what ConvertFrom-Json returns.
+func decodeParam(t *testing.T, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, "s:") {
+			t.Fatalf("string %q is not tagged", v)
+		}
+		data, err := base64.StdEncoding.DecodeString(v[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	case map[string]interface{}:
+		table := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			table[key] = decodeParam(t, item)
+		}
+		return table
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = decodeParam(t, item)
+		}
+		return list
+	}
+	return value
+}
+
+// decodeParams decodes serialized as paramPreamble does.
+func decodeParams(t *testing.T, serialized string) map[string]interface{} {
+	data, err := base64.StdEncoding.DecodeString(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var params map[string]interface{}
+	if err := decoder.Decode(&params); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range params {
+		params[name] = decodeParam(t, value)
+	}
+	return params
+}
+
+// sameJSON reports whether got, once printed as JSON, is the document want.
+func sameJSON(t *testing.T, got interface{}, want string) bool {
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jsonEqual(t, string(data), want)
+}
+
+func jsonEqual(t *testing.T, a, b string) bool {
+	decode := func(s string) interface{} {
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		return v
+	}
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+func TestSerializeParamsRoundTrip(t *testing.T) {
+	for _, c := range paramCases {
+		serialized, err := serializeParams(map[string]interface{}{"value": c.value})
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		// Nothing but base64 may reach the command line.
+		if strings.Trim(serialized, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=") != "" {
+			t.Errorf("%s: serialized parameters are not base64: %q", c.name, serialized)
+		}
+
+		got := decodeParams(t, serialized)["value"]
+		if !sameJSON(t, got, c.want) {
+			t.Errorf("%s: decoded %#v, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSerializeParamsEmpty(t *testing.T) {
+	for _, params := range []map[string]interface{}{nil, {}} {
+		serialized, err := serializeParams(params)
+		if err != nil || serialized != "" {
+			t.Errorf("serializeParams(%v) = %q, %v; want empty", params, serialized, err)
+		}
+	}
+}
+
+func TestSerializeParamsUnsupported(t *testing.T) {
+	for name, value := range map[string]interface{}{
+		"int keys": map[int]string{1: "a"},
+		"channel":  make(chan int),
+		"function": func() {},
+		"nested":   map[string]interface{}{"a": []interface{}{make(chan int)}},
+	} {
+		if _, err := serializeParams(map[string]interface{}{"value": value}); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestSerializeParamsProperties(t *testing.T) {
+	roundTrip := func(value interface{}) bool {
+		serialized, err := serializeParams(map[string]interface{}{"value": value})
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		want, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sameJSON(t, decodeParams(t, serialized)["value"], string(want))
+	}
+
+	for name, f := range map[string]interface{}{
+		"string":     func(v string) bool { return roundTrip(v) },
+		"bytes":      func(v []byte) bool { return roundTrip(string(v)) },
+		"int64":      func(v int64) bool { return roundTrip(v) },
+		"uint32":     func(v uint32) bool { return roundTrip(v) },
+		"bool":       func(v bool) bool { return roundTrip(v) },
+		"strings":    func(v []string) bool { return v == nil || roundTrip(v) },
+		"string map": func(v map[string]string) bool { return v == nil || roundTrip(v) },
+		"nested map": func(v map[string][]int32) bool { return v == nil || roundTrip(v) },
+	} {
+		if err := quick.Check(f, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// TestParamPreamble decodes the parameters with the real preamble, when
+// PowerShell is installed.
+func TestParamPreamble(t *testing.T) {
+	powershell, err := FindPowerShell()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	ps := &PSRemote{PowerShellPath: powershell.Path}
+	for _, c := range paramCases {
+		var got json.RawMessage
+		// The comma keeps arrays whole through the pipeline.
+		err := ps.OutputJSON(",$value", map[string]interface{}{"value": c.value}, &got)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(got) == 0 {
+			got = json.RawMessage("null")
+		}
+		if !jsonEqual(t, string(got), c.want) {
+			t.Errorf("%s: PowerShell decoded %s, want %s", c.name, got, c.want)
+		}
+	}
+}
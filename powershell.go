@@ -0,0 +1,125 @@
+package psremote
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Edition identifies a PowerShell distribution.
+type Edition string
+
+const (
+	// EditionDesktop is Windows PowerShell 5.1 and earlier, powershell.exe.
+	EditionDesktop Edition = "powershell"
+	// EditionCore is PowerShell 6 and later, pwsh, which also runs on
+	// Linux and macOS.
+	EditionCore Edition = "pwsh"
+)
+
+// DefaultEditions is the discovery order used when PSRemote.Editions is
+// empty. Windows prefers the built in Windows PowerShell.
+var DefaultEditions = defaultEditions()
+
+func defaultEditions() []Edition {
+	if runtime.GOOS == "windows" {
+		return []Edition{EditionDesktop, EditionCore}
+	}
+	return []Edition{EditionCore, EditionDesktop}
+}
+
+// coreInstallPaths are well known pwsh locations checked when pwsh is not
+// on the PATH.
+var coreInstallPaths = map[string][]string{
+	"windows": {
+		`C:\Program Files\PowerShell\7\pwsh.exe`,
+		`C:\Program Files\PowerShell\6\pwsh.exe`,
+	},
+	"linux": {
+		"/opt/microsoft/powershell/7/pwsh",
+		"/snap/bin/pwsh",
+	},
+	"darwin": {
+		"/usr/local/microsoft/powershell/7/pwsh",
+		"/usr/local/bin/pwsh",
+		"/opt/homebrew/bin/pwsh",
+	},
+}
+
+// PowerShell is a PowerShell binary and the edition it belongs to.
+type PowerShell struct {
+	Path    string
+	Edition Edition
+}
+
+// EditionOf guesses the edition of a PowerShell binary from its file name.
+func EditionOf(path string) Edition {
+	// The path may name a binary on another platform, so split on
+	// either separator.
+	name := strings.ToLower(path[strings.LastIndexAny(path, `/\`)+1:])
+	name = strings.TrimSuffix(name, ".exe")
+	if name == string(EditionCore) {
+		return EditionCore
+	}
+	return EditionDesktop
+}
+
+// FindPowerShell returns the first of the given editions that can be
+// found, trying DefaultEditions when none are given.
+func FindPowerShell(editions ...Edition) (PowerShell, error) {
+	if len(editions) == 0 {
+		editions = DefaultEditions
+	}
+
+	for _, edition := range editions {
+		if path, err := exec.LookPath(string(edition)); err == nil {
+			return PowerShell{Path: path, Edition: edition}, nil
+		}
+
+		if edition == EditionCore {
+			for _, path := range coreInstallPaths[runtime.GOOS] {
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					return PowerShell{Path: path, Edition: edition}, nil
+				}
+			}
+		}
+	}
+
+	return PowerShell{}, fmt.Errorf("%w (tried %v)", ErrPowerShellNotFound, editions)
+}
+
+// powerShell resolves the binary to run, honouring PowerShellPath and
+// Editions.
+func (ps *PSRemote) powerShell() (PowerShell, error) {
+	if ps.PowerShellPath != "" {
+		return PowerShell{Path: ps.PowerShellPath, Edition: EditionOf(ps.PowerShellPath)}, nil
+	}
+	return FindPowerShell(ps.editions()...)
+}
+
+func (ps *PSRemote) editions() []Edition {
+	if len(ps.Editions) > 0 {
+		return ps.Editions
+	}
+	// Only PowerShell 7 can remote over SSH.
+	if ps.Transport == TransportSSH {
+		return []Edition{EditionCore}
+	}
+	return DefaultEditions
+}
+
+// powerShellArgs returns the leading command line arguments for edition.
+func powerShellArgs(edition Edition) []string {
+	if edition == EditionCore {
+		args := []string{"-NoLogo", "-NoProfile", "-NonInteractive"}
+		// Execution policies only exist on Windows.
+		if runtime.GOOS == "windows" {
+			args = append(args, "-ExecutionPolicy", "Bypass")
+		}
+		return args
+	}
+
+	return []string{"-ExecutionPolicy", "Bypass", "-NoProfile"}
+}
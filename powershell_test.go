@@ -0,0 +1,140 @@
+package psremote
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestEditionOf(t *testing.T) {
+	for path, want := range map[string]Edition{
+		"pwsh":                                   EditionCore,
+		"/usr/bin/pwsh":                          EditionCore,
+		`C:\Program Files\PowerShell\7\pwsh.exe`: EditionCore,
+		`C:\Program Files\PowerShell\7\PWSH.EXE`: EditionCore,
+		"powershell":                             EditionDesktop,
+		`C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`: EditionDesktop,
+	} {
+		if got := EditionOf(path); got != want {
+			t.Errorf("EditionOf(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+// fakePowerShells puts executables named after editions on a new PATH.
+func fakePowerShells(t *testing.T, editions ...Edition) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs executable scripts")
+	}
+
+	dir, err := ioutil.TempDir("", "psremote-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, edition := range editions {
+		if err := ioutil.WriteFile(filepath.Join(dir, string(edition)), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+
+	// Keep installed copies out of the way.
+	saved := coreInstallPaths
+	coreInstallPaths = map[string][]string{}
+	t.Cleanup(func() { coreInstallPaths = saved })
+
+	return dir
+}
+
+func TestFindPowerShell(t *testing.T) {
+	dir := fakePowerShells(t, EditionCore, EditionDesktop)
+
+	for _, c := range []struct {
+		editions []Edition
+		want     Edition
+	}{
+		{[]Edition{EditionCore, EditionDesktop}, EditionCore},
+		{[]Edition{EditionDesktop, EditionCore}, EditionDesktop},
+		{[]Edition{EditionDesktop}, EditionDesktop},
+	} {
+		powershell, err := FindPowerShell(c.editions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := PowerShell{Path: filepath.Join(dir, string(c.want)), Edition: c.want}
+		if powershell != want {
+			t.Errorf("FindPowerShell(%v) = %+v, want %+v", c.editions, powershell, want)
+		}
+	}
+}
+
+func TestFindPowerShellFallsBack(t *testing.T) {
+	dir := fakePowerShells(t, EditionDesktop)
+
+	// Only the edition that exists is found.
+	powershell, err := FindPowerShell(EditionCore, EditionDesktop)
+	if err != nil || powershell.Edition != EditionDesktop {
+		t.Errorf("FindPowerShell = %+v, %v; want Windows PowerShell", powershell, err)
+	}
+
+	// pwsh is looked for in its install locations.
+	installed := filepath.Join(dir, "installed-pwsh")
+	ioutil.WriteFile(installed, []byte("#!/bin/sh\n"), 0755)
+	coreInstallPaths[runtime.GOOS] = []string{filepath.Join(dir, "missing"), installed}
+
+	powershell, err = FindPowerShell(EditionCore)
+	if err != nil || powershell.Path != installed {
+		t.Errorf("FindPowerShell = %+v, %v; want %s", powershell, err, installed)
+	}
+}
+
+func TestFindPowerShellNotFound(t *testing.T) {
+	fakePowerShells(t)
+
+	_, err := FindPowerShell(EditionCore, EditionDesktop)
+	if !errors.Is(err, ErrPowerShellNotFound) {
+		t.Errorf("error %v, want ErrPowerShellNotFound", err)
+	}
+}
+
+func TestPSRemoteEditions(t *testing.T) {
+	fakePowerShells(t, EditionCore, EditionDesktop)
+
+	ps := &PSRemote{Editions: []Edition{EditionDesktop}}
+	if powershell, _ := ps.powerShell(); powershell.Edition != EditionDesktop {
+		t.Errorf("Editions not honoured, found %+v", powershell)
+	}
+
+	ps = &PSRemote{Editions: []Edition{EditionDesktop}, PowerShellPath: "/opt/pwsh"}
+	if powershell, _ := ps.powerShell(); powershell != (PowerShell{Path: "/opt/pwsh", Edition: EditionCore}) {
+		t.Errorf("PowerShellPath not honoured, found %+v", powershell)
+	}
+
+	// Only PowerShell 7 remotes over SSH.
+	ps = &PSRemote{Transport: TransportSSH}
+	if editions := ps.editions(); !reflect.DeepEqual(editions, []Edition{EditionCore}) {
+		t.Errorf("SSH editions %v, want pwsh only", editions)
+	}
+}
+
+func TestPowerShellArgs(t *testing.T) {
+	core := []string{"-NoLogo", "-NoProfile", "-NonInteractive"}
+	if runtime.GOOS == "windows" {
+		core = append(core, "-ExecutionPolicy", "Bypass")
+	}
+
+	for edition, want := range map[Edition][]string{
+		EditionCore:    core,
+		EditionDesktop: {"-ExecutionPolicy", "Bypass", "-NoProfile"},
+	} {
+		if got := powerShellArgs(edition); !reflect.DeepEqual(got, want) {
+			t.Errorf("powerShellArgs(%s) = %v, want %v", edition, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+//go:build !windows
+
+package psremote
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// alive reports whether pid is still running. A zombie waiting to be
+// reaped by init counts as dead.
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// TestCancelKillsProcessTree runs a stand-in for PowerShell that starts a
+// child, and checks that cancelling the call kills both.
+func TestCancelKillsProcessTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psremote-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidFile := filepath.Join(dir, "child.pid")
+	powershell := filepath.Join(dir, "pwsh")
+	script := "#!/bin/sh\nsleep 60 &\necho $! > " + pidFile + ".tmp\nmv " + pidFile + ".tmp " + pidFile + "\nwait\n"
+	if err := ioutil.WriteFile(powershell, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var childPid int
+	go func() {
+		for ctx.Err() == nil {
+			if data, err := ioutil.ReadFile(pidFile); err == nil {
+				childPid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	ps := &PSRemote{PowerShellPath: powershell}
+	start := time.Now()
+	_, err = ps.OutputContext(ctx, "Start-Sleep 60", nil)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 30*time.Second {
+		t.Error("the call waited for the child to finish")
+	}
+	if childPid == 0 {
+		t.Fatal("the child did not start")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(childPid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(childPid, syscall.SIGKILL)
+			t.Fatalf("child %d outlived the cancelled call", childPid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package psremote
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+const createNewProcessGroup = 0x00000200
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: createNewProcessGroup}
+}
+
+// killProcessTree relies on taskkill, which walks the child processes
+// that os.Process.Kill would leave running.
+func killProcessTree(p *os.Process) {
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid))
+	if err := kill.Run(); err != nil {
+		p.Kill()
+	}
+}
@@ -0,0 +1,110 @@
+package psremote
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error categories reported by PowerShell in CategoryInfo.Category.
+const (
+	CategoryNotSpecified        = "NotSpecified"
+	CategoryOpenError           = "OpenError"
+	CategoryCloseError          = "CloseError"
+	CategoryDeviceError         = "DeviceError"
+	CategoryDeadlockDetected    = "DeadlockDetected"
+	CategoryInvalidArgument     = "InvalidArgument"
+	CategoryInvalidData         = "InvalidData"
+	CategoryInvalidOperation    = "InvalidOperation"
+	CategoryInvalidResult       = "InvalidResult"
+	CategoryInvalidType         = "InvalidType"
+	CategoryMetadataError       = "MetadataError"
+	CategoryNotImplemented      = "NotImplemented"
+	CategoryNotInstalled        = "NotInstalled"
+	CategoryObjectNotFound      = "ObjectNotFound"
+	CategoryOperationStopped    = "OperationStopped"
+	CategoryOperationTimeout    = "OperationTimeout"
+	CategorySyntaxError         = "SyntaxError"
+	CategoryParserError         = "ParserError"
+	CategoryPermissionDenied    = "PermissionDenied"
+	CategoryResourceBusy        = "ResourceBusy"
+	CategoryResourceExists      = "ResourceExists"
+	CategoryResourceUnavailable = "ResourceUnavailable"
+	CategoryReadError           = "ReadError"
+	CategoryWriteError          = "WriteError"
+	CategoryFromStdErr          = "FromStdErr"
+	CategorySecurityError       = "SecurityError"
+	CategoryProtocolError       = "ProtocolError"
+	CategoryConnectionError     = "ConnectionError"
+	CategoryAuthenticationError = "AuthenticationError"
+	CategoryLimitsExceeded      = "LimitsExceeded"
+	CategoryQuotaExceeded       = "QuotaExceeded"
+	CategoryNotEnabled          = "NotEnabled"
+)
+
+// errorCategories maps the numeric ErrorCategory values found in
+// serialised error records to their names.
+var errorCategories = []string{
+	CategoryNotSpecified, CategoryOpenError, CategoryCloseError, CategoryDeviceError,
+	CategoryDeadlockDetected, CategoryInvalidArgument, CategoryInvalidData, CategoryInvalidOperation,
+	CategoryInvalidResult, CategoryInvalidType, CategoryMetadataError, CategoryNotImplemented,
+	CategoryNotInstalled, CategoryObjectNotFound, CategoryOperationStopped, CategoryOperationTimeout,
+	CategorySyntaxError, CategoryParserError, CategoryPermissionDenied, CategoryResourceBusy,
+	CategoryResourceExists, CategoryResourceUnavailable, CategoryReadError, CategoryWriteError,
+	CategoryFromStdErr, CategorySecurityError, CategoryProtocolError, CategoryConnectionError,
+	CategoryAuthenticationError, CategoryLimitsExceeded, CategoryQuotaExceeded, CategoryNotEnabled,
+}
+
+// CategoryInfo mirrors System.Management.Automation.ErrorCategoryInfo.
+type CategoryInfo struct {
+	Category   string
+	Activity   string
+	Reason     string
+	TargetName string
+	TargetType string
+}
+
+// String formats the category the way PowerShell does, for example
+// "ObjectNotFound: (web01:String) [Get-VM], VirtualizationException".
+func (c CategoryInfo) String() string {
+	if c.Category == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s: (%s:%s) [%s], %s", c.Category, c.TargetName, c.TargetType, c.Activity, c.Reason)
+}
+
+// PSError is a PowerShell error record reported by a script. Use
+// errors.As to retrieve it from the error returned by Output and friends.
+type PSError struct {
+	Message               string
+	FullyQualifiedErrorID string
+	CategoryInfo          CategoryInfo
+	TargetObject          string
+	ScriptStackTrace      string
+	// ExceptionType is the .NET type of the underlying exception. For
+	// errors raised on a remote host it is the remote exception's type.
+	ExceptionType string
+	// ComputerName is set for errors that originated on a remote host.
+	ComputerName string
+	// Terminating reports whether the error stopped the script.
+	Terminating bool
+	// ExitCode is the exit code of the PowerShell process.
+	ExitCode int
+	// Additional holds any further error records written by the same
+	// call, in order.
+	Additional []*PSError
+}
+
+func (e *PSError) Error() string {
+	return "PowerShell error: " + e.Message
+}
+
+// Category is shorthand for e.CategoryInfo.Category.
+func (e *PSError) Category() string {
+	return e.CategoryInfo.Category
+}
+
+// ErrorID returns the leading part of FullyQualifiedErrorID, before the
+// name of the command that raised it.
+func (e *PSError) ErrorID() string {
+	return strings.SplitN(e.FullyQualifiedErrorID, ",", 2)[0]
+}
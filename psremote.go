@@ -1,339 +1,386 @@
-package psremote
-
-import (
-	"bytes"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"os"
-	"os/exec"
-	"strconv"
-	"strings"
-)
-
-const (
-	powerShellFalse = "False"
-	powerShellTrue  = "True"
-)
-
-type PSRemote struct {
-	UserName     string
-	Password     string
-	ComputerName string
-	paramSB      string
-	replaceParam string
-	UseSSL       bool
-	Stdout       io.Writer
-	Stderr       io.Writer
-}
-
-func NewPSRemote(userName, password, computerName string, useSSL bool) (*PSRemote, error) {
-
-	psremote := new(PSRemote)
-	psremote.ComputerName = computerName
-	psremote.UserName = userName
-	psremote.Password = password
-	psremote.UseSSL = useSSL
-	psremote.replaceParam = "'`n',\"`n\""
-	psremote.paramSB = `param([string]$paramsString)
-	$paramsString = [Regex]::Escape($paramsString)
-	$params = ConvertFrom-StringData -StringData "$($paramsString  -replace` + psremote.replaceParam + `)"
-	foreach ($param in $params.GetEnumerator()){
-		Set-Variable -Name $param.key -Value $param.value
-	}`
-
-	return psremote, nil
-}
-
-func (ps *PSRemote) Run(scriptBlock string, params map[string]string) error {
-	_, err := ps.Output(scriptBlock, params)
-	return err
-}
-
-func (ps *PSRemote) RunWinRM(scriptBlock string, params map[string]string) error {
-	_, err := ps.OutputWinRm(scriptBlock, params)
-	return err
-}
-
-// Output runs the PowerShell command and returns its standard output.
-func (ps *PSRemote) Output(fileContents string, params map[string]string) (string, error) {
-
-	fileContents = ps.paramSB + fileContents
-
-	path, err := ps.getPowerShellPath()
-	if err != nil {
-		return "", err
-	}
-
-	filename, err := saveScript(fileContents)
-	if err != nil {
-		return "", err
-	}
-
-	debug := os.Getenv("PACKER_POWERSHELL_DEBUG") != ""
-	verbose := debug || os.Getenv("PACKER_POWERSHELL_VERBOSE") != ""
-
-	if !debug {
-		defer os.Remove(filename)
-	}
-
-	var stdout, stderr bytes.Buffer
-
-	args := createArgs(filename, params)
-
-	if verbose {
-		log.Printf("Run: %s %s", path, args)
-	}
-
-	command := exec.Command(path, args...)
-
-	command.Stdout = &stdout
-	command.Stderr = &stderr
-
-	err = command.Run()
-
-	if ps.Stdout != nil {
-		stdout.WriteTo(ps.Stdout)
-	}
-
-	if ps.Stderr != nil {
-		stderr.WriteTo(ps.Stderr)
-	}
-
-	stderrString := strings.TrimSpace(stderr.String())
-
-	if _, ok := err.(*exec.ExitError); ok {
-		err = fmt.Errorf("PowerShell error: %s", stderrString)
-	}
-
-	if len(stderrString) > 0 {
-		err = fmt.Errorf("PowerShell error: %s", stderrString)
-	}
-
-	stdoutString := strings.TrimSpace(stdout.String())
-
-	if verbose && stdoutString != "" {
-		log.Printf("stdout: %s", stdoutString)
-	}
-
-	// only write the stderr string if verbose because
-	// the error string will already be in the err return value.
-	if verbose && stderrString != "" {
-		log.Printf("stderr: %s", stderrString)
-	}
-
-	return stdoutString, err
-}
-
-func (ps *PSRemote) OutputWinRm(scriptBlock string, params map[string]string) (string, error) {
-
-	// Unable to escape back tick in Go
-	script := ""
-	if ps.UserName != "" && ps.Password != "" {
-		script += `$secpasswd = ConvertTo-SecureString "` + ps.Password + `" -AsPlainText -Force
-		$creds = New-Object System.Management.Automation.PSCredential ("` + ps.UserName + `", $secpasswd)
-		Invoke-Command -Computername "` + ps.ComputerName + `" -credential $creds -scriptblock {` + scriptBlock + `}`
-	} else {
-		script += `
-		Invoke-Command -Computername "` + ps.ComputerName + `" -scriptblock {` + scriptBlock + `}`
-	}
-
-	if ps.UseSSL {
-		script += ` -UseSSL`
-	}
-
-	stdoutString, err := ps.Output(script, params)
-	return stdoutString, err
-}
-
-// Serialises parameters as StringData
-func createArgs(filename string, params map[string]string) []string {
-
-	args := make([]string, 6)
-	args[0] = "-ExecutionPolicy"
-	args[1] = "Bypass"
-
-	args[2] = "-NoProfile"
-
-	args[3] = "-File"
-	args[4] = filename
-
-	arg5 := ""
-	for key, value := range params {
-		var after = key + "=" + value + "`n"
-		arg5 += after
-	}
-
-	args[5] = arg5
-
-	return args
-}
-
-func IsPowershellAvailable() (bool, string, error) {
-	path, err := exec.LookPath("powershell")
-	if err != nil {
-		return false, "", err
-	} else {
-		return true, path, err
-	}
-}
-
-func (ps *PSRemote) getPowerShellPath() (string, error) {
-	powershellAvailable, path, err := IsPowershellAvailable()
-
-	if !powershellAvailable {
-		log.Fatal("Cannot find PowerShell in the path")
-		return "", err
-	}
-
-	return path, nil
-}
-
-func saveScript(fileContents string) (string, error) {
-	file, err := ioutil.TempFile(os.TempDir(), "ps")
-	if err != nil {
-		return "", err
-	}
-
-	_, err = file.Write([]byte(fileContents))
-	if err != nil {
-		return "", err
-	}
-
-	err = file.Close()
-	if err != nil {
-		return "", err
-	}
-
-	newFilename := file.Name() + ".ps1"
-	err = os.Rename(file.Name(), newFilename)
-	if err != nil {
-		return "", err
-	}
-
-	return newFilename, nil
-}
-
-func GetHostAvailableMemory() float64 {
-
-	var script = "(Get-WmiObject Win32_OperatingSystem).FreePhysicalMemory / 1024"
-
-	var ps PSRemote
-	output, _ := ps.Output(script, nil)
-
-	freeMB, _ := strconv.ParseFloat(output, 64)
-
-	return freeMB
-}
-
-func GetHostName(ip string) (string, error) {
-
-	var script = `
-param([string]$ip)
-try {
-  $HostName = [System.Net.Dns]::GetHostEntry($ip).HostName
-  if ($HostName -ne $null) {
-    $HostName = $HostName.Split('.')[0]
-  }
-  $HostName
-} catch { }
-`
-
-	//
-	var ps PSRemote
-	cmdOut, err := ps.Output(script, map[string]string{"ip": ip})
-	if err != nil {
-		return "", err
-	}
-
-	return cmdOut, nil
-}
-
-func IsCurrentUserAnAdministrator() (bool, error) {
-	var script = `
-$identity = [System.Security.Principal.WindowsIdentity]::GetCurrent()
-$principal = new-object System.Security.Principal.WindowsPrincipal($identity)
-$administratorRole = [System.Security.Principal.WindowsBuiltInRole]::Administrator
-return $principal.IsInRole($administratorRole)
-`
-
-	var ps PSRemote
-	cmdOut, err := ps.Output(script, nil)
-	if err != nil {
-		return false, err
-	}
-
-	res := strings.TrimSpace(cmdOut)
-	return res == powerShellTrue, nil
-}
-
-func ModuleExists(moduleName string) (bool, error) {
-
-	var script = `
-param([string]$moduleName)
-(Get-Module -Name $moduleName) -ne $null
-`
-	var ps PSRemote
-	cmdOut, err := ps.Output(script, nil)
-	if err != nil {
-		return false, err
-	}
-
-	res := strings.TrimSpace(cmdOut)
-
-	if res == powerShellFalse {
-		err := fmt.Errorf("PowerShell %s module is not loaded. Make sure %s feature is on.", moduleName, moduleName)
-		return false, err
-	}
-
-	return true, nil
-}
-
-func HasVirtualMachineVirtualizationExtensions() (bool, error) {
-
-	var script = `
-(GET-Command Set-VMProcessor).parameters.keys -contains "ExposeVirtualizationExtensions"
-`
-
-	var ps PSRemote
-	cmdOut, err := ps.Output(script, nil)
-
-	if err != nil {
-		return false, err
-	}
-
-	var hasVirtualMachineVirtualizationExtensions = strings.TrimSpace(cmdOut) == "True"
-	return hasVirtualMachineVirtualizationExtensions, err
-}
-
-func SetUnattendedProductKey(path string, productKey string) error {
-
-	var script = `
-param([string]$path,[string]$productKey)
-
-$unattend = [xml](Get-Content -Path $path)
-$ns = @{ un = 'urn:schemas-microsoft-com:unattend' }
-
-$setupNode = $unattend |
-  Select-Xml -XPath '//un:settings[@pass = "specialize"]/un:component[@name = "Microsoft-Windows-Shell-Setup"]' -Namespace $ns |
-  Select-Object -ExpandProperty Node
-
-$productKeyNode = $setupNode |
-  Select-Xml -XPath '//un:ProductKey' -Namespace $ns |
-  Select-Object -ExpandProperty Node
-
-if ($productKeyNode -eq $null) {
-    $productKeyNode = $unattend.CreateElement('ProductKey', $ns.un)
-    [Void]$setupNode.AppendChild($productKeyNode)
-}
-
-$productKeyNode.InnerText = $productKey
-
-$unattend.Save($path)
-`
-
-	var ps PSRemote
-	err := ps.Run(script, map[string]string{"path": path, "productKey": productKey})
-	return err
-}
+package psremote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/nimerix/psremote/unattend"
+)
+
+// PSRemote runs PowerShell scripts locally and on ComputerName. It is safe
+// for concurrent use. Its fields are copied by the first call, and later
+// changes to them have no effect; what they point to, such as RetryPolicy
+// or a CredentialProvider, is shared by every call and must be safe for
+// concurrent use itself.
+type PSRemote struct {
+	UserName     string
+	Password     string
+	ComputerName string
+	UseSSL       bool
+	// Stdout and Stderr receive a copy of the output of every call, a
+	// line at a time, so that concurrent calls do not interleave within
+	// a line.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Executor runs the generated scripts. When nil a LocalExecutor is
+	// used, or a WinRM client for remote scripts over TransportWinRM.
+	Executor Executor
+	// Credentials, when set, supplies the credential for ComputerName
+	// instead of UserName and Password.
+	Credentials CredentialProvider
+	// JSONDepth is the ConvertTo-Json depth used by OutputJSON. Zero
+	// selects DefaultJSONDepth.
+	JSONDepth int
+	// PowerShellPath, when set, is the PowerShell binary to run. Otherwise
+	// the first of Editions, or DefaultEditions, found on this host is used.
+	PowerShellPath string
+	Editions       []Edition
+	// ScriptMode selects how scripts are passed to the local PowerShell.
+	// The default writes them to a private temporary file.
+	ScriptMode ScriptMode
+	// FailurePolicy selects which script results are returned as errors.
+	FailurePolicy FailurePolicy
+	// Transport selects how OutputWinRm reaches ComputerName.
+	Transport Transport
+	// Port is the WinRM port of ComputerName. Zero selects 5985, or 5986
+	// with UseSSL, or 22 with TransportSSH.
+	Port int
+	// KeyFilePath is the SSH private key used with TransportSSH. When
+	// empty ssh picks the key as usual.
+	KeyFilePath string
+	// Authentication selects the WinRM authentication mechanism. Empty
+	// leaves PowerShell's default. TransportWinRM only supports Basic.
+	Authentication Authentication
+	// ConfigurationName and ApplicationName select the session
+	// configuration and the WinRM application, when they are not the
+	// defaults.
+	ConfigurationName string
+	ApplicationName   string
+	SessionOptions    SessionOptions
+	// HTTPClient is used by TransportWinRM. When nil a client applying
+	// SessionOptions is used.
+	HTTPClient *http.Client
+	// OnLine, when set, is called with each line of output as it arrives,
+	// tagged with the stream it was written to. It is never called
+	// concurrently, even by concurrent calls, and holds up all output
+	// while it runs, so it must not make calls on the same PSRemote.
+	// Output is also copied to Stdout and Stderr as it arrives.
+	OnLine func(stream Stream, line string)
+	// Logger receives the log records selected by LogLevel. When nil the
+	// standard log package is used.
+	Logger   Logger
+	LogLevel LogLevel
+	// RetryPolicy, when set, retries transient failures of calls whose
+	// context is marked with RetrySafe. Other calls are never retried.
+	RetryPolicy *RetryPolicy
+	// Recorder, when set, records every command and its result to a
+	// transcript that ReplayExecutor can serve.
+	Recorder *Recorder
+	// Limiter, when set, limits the calls running at once against
+	// ComputerName. Each attempt of a retried call is limited on its own.
+	Limiter *HostLimiter
+
+	// config is the copy of the fields used by every call. It alone
+	// holds the session, secrets and output lock.
+	configOnce sync.Once
+	config     *PSRemote
+	isConfig   bool
+
+	sessionMu sync.Mutex
+	session   *session
+	secretsMu sync.Mutex
+	secrets   []string
+	// outputMu serialises writes to Stdout, Stderr and OnLine.
+	outputMu sync.Mutex
+}
+
+// configured returns the copy of ps's configuration that calls use, taking
+// it on first use.
+func (ps *PSRemote) configured() *PSRemote {
+	if ps.isConfig {
+		return ps
+	}
+
+	ps.configOnce.Do(func() {
+		config := &PSRemote{isConfig: true}
+		src, dst := reflect.ValueOf(ps).Elem(), reflect.ValueOf(config).Elem()
+		for i := 0; i < src.NumField(); i++ {
+			if src.Type().Field(i).IsExported() {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+		config.Editions = append([]Edition(nil), ps.Editions...)
+		ps.config = config
+	})
+	return ps.config
+}
+
+func NewPSRemote(userName, password, computerName string, useSSL bool) (*PSRemote, error) {
+
+	psremote := new(PSRemote)
+	psremote.ComputerName = computerName
+	psremote.UserName = userName
+	psremote.Password = password
+	psremote.UseSSL = useSSL
+
+	return psremote, nil
+}
+
+func (ps *PSRemote) Run(scriptBlock string, params map[string]interface{}) error {
+	return ps.RunContext(context.Background(), scriptBlock, params)
+}
+
+// RunContext is like Run but stops PowerShell when ctx is done.
+func (ps *PSRemote) RunContext(ctx context.Context, scriptBlock string, params map[string]interface{}) error {
+	_, err := ps.OutputContext(ctx, scriptBlock, params)
+	return err
+}
+
+func (ps *PSRemote) RunWinRM(scriptBlock string, params map[string]interface{}) error {
+	return ps.RunWinRMContext(context.Background(), scriptBlock, params)
+}
+
+// RunWinRMContext is like RunWinRM but stops PowerShell when ctx is done.
+func (ps *PSRemote) RunWinRMContext(ctx context.Context, scriptBlock string, params map[string]interface{}) error {
+	_, err := ps.OutputWinRmContext(ctx, scriptBlock, params)
+	return err
+}
+
+// Output runs the PowerShell command and returns its standard output.
+// Whether it failed is decided by FailurePolicy.
+func (ps *PSRemote) Output(fileContents string, params map[string]interface{}) (string, error) {
+	return ps.OutputContext(context.Background(), fileContents, params)
+}
+
+// OutputContext is like Output but kills the PowerShell process tree when
+// ctx is done, in which case the error is a *CanceledError.
+func (ps *PSRemote) OutputContext(ctx context.Context, fileContents string, params map[string]interface{}) (string, error) {
+	result, err := ps.OutputResultContext(ctx, fileContents, params)
+	return result.stdout(), err
+}
+
+// OutputResult is like Output but returns the whole Result. When the
+// script ran but failed, the Result is returned along with the error.
+func (ps *PSRemote) OutputResult(fileContents string, params map[string]interface{}) (*Result, error) {
+	return ps.OutputResultContext(context.Background(), fileContents, params)
+}
+
+func (ps *PSRemote) OutputResultContext(ctx context.Context, fileContents string, params map[string]interface{}) (*Result, error) {
+	ps = ps.configured()
+	return ps.withRetry(ctx, func(ctx context.Context) (*Result, error) {
+		if session := ps.currentSession(); session != nil {
+			return session.output(ctx, fileContents, params, false)
+		}
+		return ps.output(ctx, fileContents, params, nil)
+	})
+}
+
+// output runs fileContents through a local PowerShell with stdin attached
+// to its standard input.
+func (ps *PSRemote) output(ctx context.Context, fileContents string, params map[string]interface{}, stdin io.Reader) (*Result, error) {
+
+	fileContents = paramPreamble + wrapErrors(fileContents)
+
+	powershell, err := ps.getPowerShellPath()
+	if err != nil {
+		return nil, err
+	}
+
+	serialized, err := serializeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.logDebug("PowerShell script", "script", fileContents)
+
+	command := &Command{
+		Path:   powershell.Path,
+		Script: fileContents,
+		Params: params,
+		Stdin:  stdin,
+	}
+
+	cleanup, err := ps.scriptCommand(command, powershell.Edition, serialized)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	return ps.run(ctx, ps.executor(), command)
+}
+
+func (ps *PSRemote) run(ctx context.Context, executor Executor, command *Command) (*Result, error) {
+
+	ps.logVerbose("running PowerShell", "path", command.Path, "args", commandArgs(command), "params", command.Params)
+
+	stdout, stderr := ps.outputWriters()
+
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	if ps.Recorder != nil {
+		executor = recordingExecutor{recorder: ps.Recorder, executor: executor}
+	}
+
+	err := executor.Run(ctx, command)
+
+	return ps.result(ctx, stdout, stderr, err)
+}
+
+func (ps *PSRemote) OutputWinRm(scriptBlock string, params map[string]interface{}) (string, error) {
+	return ps.OutputWinRmContext(context.Background(), scriptBlock, params)
+}
+
+// OutputWinRmContext is like OutputWinRm but stops the remote call when ctx
+// is done, in which case the error is a *CanceledError.
+func (ps *PSRemote) OutputWinRmContext(ctx context.Context, scriptBlock string, params map[string]interface{}) (string, error) {
+	result, err := ps.OutputWinRmResultContext(ctx, scriptBlock, params)
+	return result.stdout(), err
+}
+
+// OutputWinRmResult is like OutputWinRm but returns the whole Result, see
+// OutputResult.
+func (ps *PSRemote) OutputWinRmResult(scriptBlock string, params map[string]interface{}) (*Result, error) {
+	return ps.OutputWinRmResultContext(context.Background(), scriptBlock, params)
+}
+
+func (ps *PSRemote) OutputWinRmResultContext(ctx context.Context, scriptBlock string, params map[string]interface{}) (*Result, error) {
+	ps = ps.configured()
+	return ps.withRetry(ctx, func(ctx context.Context) (*Result, error) {
+		return ps.outputWinRm(ctx, scriptBlock, params)
+	})
+}
+
+func (ps *PSRemote) outputWinRm(ctx context.Context, scriptBlock string, params map[string]interface{}) (*Result, error) {
+
+	if ps.Transport == TransportWinRM {
+		return ps.outputNative(ctx, scriptBlock, params)
+	}
+
+	if session := ps.currentSession(); session != nil {
+		return session.output(ctx, remoteScriptBlock("-Session $Session", scriptBlock), params, true)
+	}
+
+	remoting, err := ps.remotingScript(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The credential is read from stdin so that it never appears in
+	// the script, which may be logged or written to disk.
+	script := credentialScript + `$psremoteCredential = Read-PSRemoteCredential
+` + remoting + remoteScriptBlock("@psremoteArgs", scriptBlock)
+
+	return ps.outputWithCredential(ctx, script, params)
+}
+
+// OutputSession runs script through the local PowerShell with $Session
+// bound to a PSSession on ComputerName, for commands such as Copy-Item
+// -ToSession that need both ends. The session is removed afterwards.
+func (ps *PSRemote) OutputSession(script string, params map[string]interface{}) (string, error) {
+	return ps.OutputSessionContext(context.Background(), script, params)
+}
+
+// OutputSessionContext is like OutputSession but kills the PowerShell
+// process tree when ctx is done, in which case the error is a
+// *CanceledError.
+func (ps *PSRemote) OutputSessionContext(ctx context.Context, script string, params map[string]interface{}) (string, error) {
+	ps = ps.configured()
+	result, err := ps.withRetry(ctx, func(ctx context.Context) (*Result, error) {
+		return ps.outputSession(ctx, script, params)
+	})
+	return result.stdout(), err
+}
+
+func (ps *PSRemote) outputSession(ctx context.Context, script string, params map[string]interface{}) (*Result, error) {
+
+	if session := ps.currentSession(); session != nil {
+		return session.output(ctx, script, params, true)
+	}
+
+	remoting, err := ps.remotingScript(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session := credentialScript + `$psremoteCredential = Read-PSRemoteCredential
+` + remoting + `$Session = New-PSSession @psremoteArgs
+try {
+` + script + `
+} finally {
+	Remove-PSSession -Session $Session
+}`
+
+	return ps.outputWithCredential(ctx, session, params)
+}
+
+// outputWithCredential runs script locally with the credential for
+// ComputerName on stdin, for Read-PSRemoteCredential.
+func (ps *PSRemote) outputWithCredential(ctx context.Context, script string, params map[string]interface{}) (*Result, error) {
+
+	input, err := ps.remoteCredentialInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ps.output(ctx, script, params, strings.NewReader(input))
+}
+
+func (ps *PSRemote) executor() Executor {
+	if ps.Executor != nil {
+		return ps.Executor
+	}
+	return LocalExecutor{}
+}
+
+// IsPowershellAvailable reports whether any PowerShell edition can be
+// found, trying DefaultEditions in order.
+func IsPowershellAvailable() (bool, string, error) {
+	powershell, err := FindPowerShell()
+	if err != nil {
+		return false, "", err
+	} else {
+		return true, powershell.Path, err
+	}
+}
+
+func (ps *PSRemote) getPowerShellPath() (PowerShell, error) {
+	powershell, err := ps.powerShell()
+
+	// A custom executor may not run locally, so fall back to the
+	// preferred edition's name rather than requiring it on this host.
+	if err != nil && ps.Executor != nil {
+		edition := ps.editions()[0]
+		powershell, err = PowerShell{Path: string(edition), Edition: edition}, nil
+	}
+
+	if err != nil {
+		return PowerShell{}, err
+	}
+
+	if ps.Transport == TransportSSH && powershell.Edition != EditionCore {
+		return PowerShell{}, fmt.Errorf("%w: TransportSSH needs %s, not %s", ErrUnsupportedOption, EditionCore, powershell.Path)
+	}
+
+	return powershell, nil
+}
+
+// SetUnattendedProductKey sets the product key in the answer file at path,
+// see unattend.Document.SetProductKey. It does not need PowerShell.
+//
+// The file is written back from its parsed form, so XML comments in it
+// are dropped and it is laid out as unattend.Document.Bytes writes it.
+func SetUnattendedProductKey(path string, productKey string) error {
+	doc, err := unattend.Load(path)
+	if err != nil {
+		return err
+	}
+
+	doc.SetProductKey(productKey)
+	return doc.Save(path)
+}
@@ -0,0 +1,116 @@
+package psremote
+
+import (
+	"context"
+	"strings"
+)
+
+// Result is the outcome of a script that ran, whether or not it counts as
+// a failure.
+type Result struct {
+	Stdout string
+	Stderr string
+	// ExitCode is the exit code of the script, or of the last native
+	// command it ran.
+	ExitCode int
+	// Warnings are the messages of the script's warning records.
+	Warnings []string
+	// HadErrors reports whether the script wrote any error record,
+	// terminating or not.
+	HadErrors bool
+}
+
+// FailurePolicy selects which results make a call fail.
+type FailurePolicy int
+
+const (
+	// FailOnError fails on any error record and on a non-zero exit code.
+	FailOnError FailurePolicy = iota
+	// FailOnTerminatingError only fails when the script stopped on a
+	// terminating error, or PowerShell could not run it at all.
+	// Non-terminating errors and exit codes are left to the caller.
+	FailOnTerminatingError
+	// FailOnExitCode only fails on a non-zero exit code. Terminating
+	// errors exit with 1, so they fail too.
+	FailOnExitCode
+)
+
+func (r *Result) stdout() string {
+	if r == nil {
+		return ""
+	}
+	return r.Stdout
+}
+
+// result turns the output of a finished script into a Result, and an
+// error when it failed by FailurePolicy.
+func (ps *PSRemote) result(ctx context.Context, stdout, stderr *outputWriter, err error) (*Result, error) {
+
+	stdout.flush()
+	stderr.flush()
+
+	result := &Result{
+		Stdout: strings.TrimSpace(stdout.String()),
+		Stderr: strings.TrimSpace(stderr.String()),
+	}
+
+	if ctx.Err() != nil {
+		ps.logVerbose("PowerShell stopped", "error", ctx.Err())
+		return result, &CanceledError{Err: ctx.Err()}
+	}
+
+	if result.Stdout != "" {
+		ps.logVerbose("PowerShell stdout", "output", result.Stdout)
+	}
+
+	// only log the stderr string if verbose because
+	// the error string will already be in the err return value.
+	if result.Stderr != "" {
+		ps.logVerbose("PowerShell stderr", "output", result.Stderr)
+	}
+
+	// Any error other than an exit code means the script could not be
+	// run at all and is already classified.
+	exitErr, ok := err.(interface{ ExitCode() int })
+	if err != nil && !ok {
+		return result, err
+	}
+	if ok {
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	records := parseStderr(result.Stderr)
+	terminating := false
+	for _, r := range records {
+		switch r.stream {
+		case clixmlWarning:
+			result.Warnings = append(result.Warnings, r.text)
+		case clixmlError:
+			result.HadErrors = true
+			// Plain text comes from PowerShell itself rather than the
+			// script, such as a parse error, so nothing ran.
+			if r.err == nil || r.err.Terminating {
+				terminating = true
+			}
+		}
+	}
+
+	var failed bool
+	switch ps.FailurePolicy {
+	case FailOnTerminatingError:
+		failed = terminating
+	case FailOnExitCode:
+		failed = result.ExitCode != 0
+	default:
+		failed = result.HadErrors || result.ExitCode != 0
+	}
+	if !failed {
+		return result, nil
+	}
+
+	psErr := errorFromRecords(records, result.ExitCode)
+	if psErr == nil {
+		psErr = &PSError{Message: err.Error(), ExitCode: result.ExitCode}
+	}
+	return result, ps.scriptError(ctx, psErr, result.Stderr)
+}
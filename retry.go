@@ -0,0 +1,178 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy makes a failed call again after a growing delay. It only
+// applies to calls marked with RetrySafe, since running a script that is
+// not idempotent twice can do the work twice.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a call is made, including the
+	// first. Zero or one never retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay. Zero leaves it uncapped.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each retry. Zero means 2.
+	Multiplier float64
+	// Jitter randomly moves each delay by up to this fraction of it, so
+	// that callers failing together do not retry together.
+	Jitter float64
+	// Retryable reports whether err is worth retrying. When nil
+	// IsRetryable is used.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy retries transient failures three times over about
+// seven seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// transientMessages are fragments of WinRM and PowerShell remoting
+// messages for failures that usually pass.
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"service is busy",
+	"ws-management service cannot process the request",
+	"winrm cannot complete the operation",
+	"the client cannot connect to the destination",
+	"operation timed out",
+	"i/o timeout",
+}
+
+// IsRetryable reports whether err is a transient failure: the host could
+// not be reached, WinRM was busy or the script reported a ResourceBusy or
+// OperationTimeout error. Authentication failures, cancellation and
+// ordinary script errors are not retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrAuthentication) || errors.Is(err, ErrPowerShellNotFound) {
+		return false
+	}
+
+	var canceled *CanceledError
+	if errors.As(err, &canceled) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrConnection) {
+		return true
+	}
+
+	var psErr *PSError
+	if errors.As(err, &psErr) {
+		switch psErr.Category() {
+		case CategoryResourceBusy, CategoryOperationTimeout:
+			return true
+		}
+		if strings.Contains(psErr.FullyQualifiedErrorID, "WinRMOperationTimeout") {
+			return true
+		}
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+type retrySafeKey struct{}
+
+// RetrySafe marks calls made with the returned context as safe to retry,
+// because running their script again does no harm. policy overrides
+// PSRemote.RetryPolicy when it is not nil.
+func RetrySafe(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retrySafeKey{}, retrySafe{policy: policy})
+}
+
+type retrySafe struct {
+	policy *RetryPolicy
+}
+
+// retryPolicy returns the policy for a call made with ctx, or nil when it
+// must not be retried.
+func (ps *PSRemote) retryPolicy(ctx context.Context) *RetryPolicy {
+	safe, ok := ctx.Value(retrySafeKey{}).(retrySafe)
+	if !ok {
+		return nil
+	}
+	if safe.policy != nil {
+		return safe.policy
+	}
+	return ps.RetryPolicy
+}
+
+// withRetry makes call, and makes it again as the retry policy for ctx
+// allows while it fails with a retryable error.
+func (ps *PSRemote) withRetry(ctx context.Context, call func(ctx context.Context) (*Result, error)) (*Result, error) {
+	call = ps.limited(call)
+
+	policy := ps.retryPolicy(ctx)
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return call(ctx)
+	}
+
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		out, err := call(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return out, err
+		}
+
+		delay := policy.backoff(attempt)
+		ps.logVerbose("retrying PowerShell", "attempt", attempt+1, "delay", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &CanceledError{Err: ctx.Err()}
+		}
+	}
+}
+
+// backoff returns the delay after the given failed attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
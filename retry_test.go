@@ -0,0 +1,162 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	scriptError := func(category, id string) error {
+		return &ScriptError{ExitCode: 1, Err: &PSError{
+			Message:               "failed",
+			FullyQualifiedErrorID: id,
+			CategoryInfo:          CategoryInfo{Category: category},
+		}}
+	}
+
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unreachable host", &ConnectionError{ComputerName: "host", Err: errors.New("no route")}, true},
+		{"rejected credential", &ConnectionError{ComputerName: "host", Authentication: true, Err: errors.New("access denied")}, false},
+		{"no PowerShell", fmt.Errorf("%w: pwsh", ErrPowerShellNotFound), false},
+		{"canceled call", &CanceledError{Err: context.Canceled}, false},
+		{"context canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("waiting: %w", context.DeadlineExceeded), false},
+		{"resource busy", scriptError(CategoryResourceBusy, "Busy"), true},
+		{"operation timeout", scriptError(CategoryOperationTimeout, "Timeout"), true},
+		{"WinRM operation timeout", scriptError(CategoryNotSpecified, "WinRMOperationTimeout,PSSessionStateBroken"), true},
+		{"ordinary script error", scriptError(CategoryObjectNotFound, "PathNotFound"), false},
+		{"connection refused", errors.New("dial tcp 10.0.0.1:5985: connect: Connection refused"), true},
+		{"i/o timeout", errors.New("read tcp: i/o timeout"), true},
+		{"WinRM busy", errors.New("The WS-Management service cannot process the request."), true},
+		{"other", errors.New("disk full"), false},
+	} {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("%s: IsRetryable = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{0, 1, 2, 4, 5, 5, 5} {
+		if attempt == 0 {
+			continue
+		}
+		if got := policy.backoff(attempt); got != want*time.Second {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want*time.Second)
+		}
+	}
+
+	uncapped := &RetryPolicy{InitialBackoff: time.Second, Multiplier: 3}
+	if got := uncapped.backoff(4); got != 27*time.Second {
+		t.Errorf("uncapped backoff(4) = %s, want 27s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 4 * time.Second, Jitter: 0.2}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		delay := policy.backoff(2)
+		if delay < 1600*time.Millisecond || delay > 2400*time.Millisecond {
+			t.Fatalf("backoff(2) = %s, want 2s ± 20%%", delay)
+		}
+		seen[delay] = true
+
+		// Jitter never takes a delay past MaxBackoff.
+		if delay := policy.backoff(5); delay < 3200*time.Millisecond || delay > 4*time.Second {
+			t.Fatalf("backoff(5) = %s, want 3.2s to 4s", delay)
+		}
+	}
+	if len(seen) < 100 {
+		t.Errorf("only %d different delays", len(seen))
+	}
+}
+
+// failingExecutor fails the first failures calls with err.
+func failingExecutor(failures int, err error) *FakeExecutor {
+	calls := 0
+	return &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		calls++
+		if calls <= failures {
+			return FakeResponse{Err: err}
+		}
+		return FakeResponse{Stdout: "ok"}
+	}}
+}
+
+func TestRetry(t *testing.T) {
+	transient := errors.New("connection reset by peer")
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	safe := RetrySafe(context.Background(), nil)
+
+	for _, c := range []struct {
+		name      string
+		ctx       context.Context
+		policy    *RetryPolicy
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"not marked safe", context.Background(), policy, 5, transient, 1, true},
+		{"succeeds on retry", safe, policy, 1, transient, 2, false},
+		{"gives up after MaxAttempts", safe, policy, 5, transient, 3, true},
+		{"script error", safe, policy, 5, &ExitError{Code: 1}, 1, true},
+		{"no policy", safe, nil, 5, transient, 1, true},
+		{"one attempt", safe, &RetryPolicy{MaxAttempts: 1}, 5, transient, 1, true},
+		{"policy of the context", RetrySafe(context.Background(), &RetryPolicy{MaxAttempts: 2}), nil, 5, transient, 2, true},
+		{"classifier", RetrySafe(context.Background(), &RetryPolicy{
+			MaxAttempts: 4,
+			Retryable:   func(err error) bool { return true },
+		}), nil, 5, &ExitError{Code: 1}, 4, true},
+	} {
+		fake := failingExecutor(c.failures, c.err)
+		ps := &PSRemote{ComputerName: "host", PowerShellPath: "pwsh", Executor: fake, RetryPolicy: c.policy}
+
+		out, err := ps.OutputWinRmContext(c.ctx, "hostname", nil)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: error %v", c.name, err)
+		} else if err == nil && out != "ok" {
+			t.Errorf("%s: output %q", c.name, out)
+		}
+		if n := len(fake.Calls()); n != c.wantCalls {
+			t.Errorf("%s: %d calls, want %d", c.name, n, c.wantCalls)
+		}
+	}
+}
+
+func TestRetryCanceledDuringBackoff(t *testing.T) {
+	fake := failingExecutor(5, errors.New("connection refused"))
+	ps := &PSRemote{
+		ComputerName:   "host",
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+		RetryPolicy:    &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := ps.OutputWinRmContext(RetrySafe(ctx, nil), "hostname", nil)
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("error %v, want a *CanceledError for the deadline", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the backoff was not cut short")
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
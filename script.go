@@ -0,0 +1,97 @@
+package psremote
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ScriptMode selects how a generated script is handed to a local
+// PowerShell.
+type ScriptMode int
+
+const (
+	// ScriptFile writes the script to a file in a private temporary
+	// directory, readable only by the current user, and runs it with
+	// -File. The directory is removed when the script finishes, fails or
+	// is cancelled.
+	ScriptFile ScriptMode = iota
+	// ScriptEncodedCommand passes the script on the command line with
+	// -EncodedCommand, so nothing is written to disk. Scripts too long
+	// for a Windows command line are passed as with ScriptStdin instead.
+	ScriptEncodedCommand
+	// ScriptStdin passes a short -EncodedCommand that reads the script and
+	// its parameters from the first line of stdin, so nothing is written to
+	// disk and the command line stays short however large they are.
+	ScriptStdin
+)
+
+// maxEncodedCommand keeps -EncodedCommand well within the 32767 character
+// limit of a Windows command line, leaving room for the other arguments.
+const maxEncodedCommand = 30000
+
+// scriptCommand sets the arguments and stdin of command to run its Script
+// with the serialised parameters, as mode selects. The returned cleanup
+// function removes anything written to disk and must always be called.
+func (ps *PSRemote) scriptCommand(command *Command, edition Edition, serialized string) (func(), error) {
+	args := powerShellArgs(edition)
+
+	mode := ps.ScriptMode
+	if mode == ScriptEncodedCommand {
+		encoded := encodeCommand(invokeScript(command.Script, serialized))
+		if len(encoded) <= maxEncodedCommand {
+			command.Args = append(args, "-EncodedCommand", encoded)
+			return func() {}, nil
+		}
+		mode = ScriptStdin
+	}
+
+	if mode == ScriptStdin {
+		// The parameters go with the script, so that neither is bound by
+		// the command line length limit.
+		script := strings.NewReader(base64.StdEncoding.EncodeToString([]byte(invokeScript(command.Script, serialized))) + "\n")
+		if command.Stdin != nil {
+			command.Stdin = io.MultiReader(script, command.Stdin)
+		} else {
+			command.Stdin = script
+		}
+		command.Args = append(args, "-EncodedCommand", encodeCommand(stdinBootstrap))
+		return func() {}, nil
+	}
+
+	dir, filename, err := saveScript(command.Script)
+	if err != nil {
+		return nil, err
+	}
+	command.Args = append(args, "-File", filename, serialized)
+	return func() { os.RemoveAll(dir) }, nil
+}
+
+// invokeScript returns a command running script with the serialised
+// parameters.
+func invokeScript(script, serializedParams string) string {
+	return "& {\n" + script + "\n} '" + serializedParams + "'"
+}
+
+// saveScript writes fileContents to a new private directory and returns
+// the directory, for removal, and the script's path.
+func saveScript(fileContents string) (string, string, error) {
+	// TempDir creates the directory with mode 0700, so the script
+	// cannot be read or replaced by other users whatever its name.
+	dir, err := ioutil.TempDir("", "psremote")
+	if err != nil {
+		return "", "", err
+	}
+
+	filename := filepath.Join(dir, "script.ps1")
+	err = ioutil.WriteFile(filename, []byte(fileContents), 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	return dir, filename, nil
+}
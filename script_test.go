@@ -0,0 +1,64 @@
+package psremote
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestScriptModesKeepLargeParamsOffTheCommandLine(t *testing.T) {
+	large := strings.Repeat("x", 100000)
+
+	for _, mode := range []ScriptMode{ScriptEncodedCommand, ScriptStdin} {
+		var stdin string
+		fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+			data, _ := ioutil.ReadAll(cmd.Stdin)
+			stdin = string(data)
+			return FakeResponse{}
+		}}
+		ps := &PSRemote{Executor: fake, PowerShellPath: "pwsh", ScriptMode: mode}
+
+		if _, err := ps.Output("$value", map[string]interface{}{"value": large}); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+
+		call, _ := fake.LastCall()
+		if n := len(strings.Join(call.Args, " ")); n > maxEncodedCommand {
+			t.Errorf("mode %d: command line is %d characters", mode, n)
+		}
+
+		line := strings.SplitN(stdin, "\n", 2)[0]
+		script, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			t.Fatalf("mode %d: stdin: %v", mode, err)
+		}
+		serialized, _ := serializeParams(call.Params)
+		if !strings.Contains(string(script), serialized) {
+			t.Errorf("mode %d: parameters are not sent with the script on stdin", mode)
+		}
+	}
+}
+
+func TestScriptFileIsRemoved(t *testing.T) {
+	var path string
+	fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		for i, arg := range cmd.Args {
+			if arg == "-File" {
+				path = cmd.Args[i+1]
+			}
+		}
+		return FakeResponse{}
+	}}
+	ps := &PSRemote{Executor: fake, PowerShellPath: "pwsh"}
+
+	if _, err := ps.Output("'hello'", nil); err != nil {
+		t.Fatal(err)
+	}
+	if path == "" {
+		t.Fatal("no -File argument")
+	}
+	if _, err := ioutil.ReadFile(path); err == nil {
+		t.Errorf("%s was not removed", path)
+	}
+}
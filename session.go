@@ -0,0 +1,449 @@
+package psremote
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionCloseTimeout is how long Close waits for the session host to
+// remove its PSSession and exit before killing it.
+const sessionCloseTimeout = 30 * time.Second
+
+var (
+	errSessionClosed = errors.New("PowerShell session is closed")
+	errSessionEnded  = errors.New("PowerShell session ended unexpectedly")
+)
+
+// OpenSession keeps one local PowerShell process, and one PSSession to
+// ComputerName, open for every following call until Close. Scripts are
+// fed to it over stdin instead of starting PowerShell and connecting for
+// each call. A session that fails is started again on the next call.
+//
+// The session host is started through Executor as a single command that
+// runs until Close, reading requests from its stdin. Calls made in the
+// session are not recorded by Recorder, since they never reach Executor
+// on their own. OutputWinRm over TransportWinRM does not go through the
+// session.
+func (ps *PSRemote) OpenSession() error {
+	return ps.OpenSessionContext(context.Background())
+}
+
+// OpenSessionContext is like OpenSession but gives up when ctx is done.
+func (ps *PSRemote) OpenSessionContext(ctx context.Context) error {
+	ps = ps.configured()
+
+	// Calls wait while the session opens, rather than each starting
+	// PowerShell of their own.
+	ps.sessionMu.Lock()
+	defer ps.sessionMu.Unlock()
+
+	if ps.session != nil {
+		return nil
+	}
+
+	// Run an empty script so that a bad host or credential is reported
+	// here rather than by the first real call.
+	s := &session{ps: ps}
+	if _, err := s.output(ctx, "", nil, true); err != nil {
+		s.close()
+		return err
+	}
+
+	ps.session = s
+	return nil
+}
+
+// Close ends the session opened by OpenSession, if any. Calls still
+// running in the session fail.
+func (ps *PSRemote) Close() error {
+	ps = ps.configured()
+
+	ps.sessionMu.Lock()
+	s := ps.session
+	ps.session = nil
+	ps.sessionMu.Unlock()
+
+	if s == nil {
+		return nil
+	}
+	return s.close()
+}
+
+// currentSession returns the session opened by OpenSession, or nil.
+func (ps *PSRemote) currentSession() *session {
+	ps.sessionMu.Lock()
+	defer ps.sessionMu.Unlock()
+
+	return ps.session
+}
+
+// session runs scripts one at a time through a sessionHost, starting a new
+// host whenever the previous one has gone.
+type session struct {
+	ps     *PSRemote
+	mu     sync.Mutex
+	host   *sessionHost
+	closed bool
+}
+
+// output runs script in the session host. With remote set the host first
+// makes sure $Session is an open PSSession to ComputerName.
+func (s *session) output(ctx context.Context, script string, params map[string]interface{}, remote bool) (*Result, error) {
+
+	serialized, err := serializeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	flag := "0"
+	if remote {
+		flag = "1"
+	}
+
+	s.ps.logDebug("PowerShell script", "script", paramPreamble+script)
+	s.ps.logVerbose("running PowerShell in session", "params", params)
+
+	request := flag + " " + base64.StdEncoding.EncodeToString([]byte(paramPreamble+script)) + " " + serialized + "\n"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errSessionClosed
+	}
+
+	host, err := s.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := host.send(request); err != nil {
+		// The host went away before reading the request, so nothing
+		// ran and it is safe to send it to a new one.
+		s.stop()
+		if host, err = s.start(ctx); err != nil {
+			return nil, err
+		}
+		if err := host.send(request); err != nil {
+			s.stop()
+			return nil, err
+		}
+	}
+
+	stdout, stderr := s.ps.outputWriters()
+
+	exitCode, err := host.receive(ctx, stdout, stderr)
+	if err != nil {
+		s.stop()
+	} else if exitCode != 0 {
+		err = &ExitError{Code: exitCode}
+	}
+
+	return s.ps.result(ctx, stdout, stderr, err)
+}
+
+// start returns the running host, starting a new one if there is none or
+// it has exited.
+func (s *session) start(ctx context.Context) (*sessionHost, error) {
+	if s.host != nil {
+		select {
+		case <-s.host.exited:
+			s.host = nil
+		default:
+			return s.host, nil
+		}
+	}
+
+	host, err := s.ps.startSessionHost(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.host = host
+	return host, nil
+}
+
+func (s *session) stop() {
+	if s.host != nil {
+		s.host.kill()
+		s.host = nil
+	}
+}
+
+func (s *session) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.host == nil {
+		return nil
+	}
+
+	host := s.host
+	s.host = nil
+
+	// The host removes its PSSession and exits at the end of stdin.
+	host.stdin.Close()
+
+	if !host.wait(time.After(sessionCloseTimeout)) {
+		host.kill()
+		return errors.New("PowerShell session did not exit and was killed")
+	}
+
+	return nil
+}
+
+// sessionHost is a PowerShell process executing sessionHostScript, run
+// through Executor as one long command.
+type sessionHost struct {
+	stdin  io.WriteCloser
+	stdout chan string
+	stderr chan string
+	exited chan struct{}
+	cancel context.CancelFunc
+	marker string
+	// err is what Executor returned, set before exited is closed.
+	err error
+}
+
+func (ps *PSRemote) startSessionHost(ctx context.Context) (*sessionHost, error) {
+
+	powershell, err := ps.getPowerShellPath()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := ps.remoteCredentialInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	remoting, err := ps.remotingScript(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	marker, err := newMarker()
+	if err != nil {
+		return nil, err
+	}
+
+	// Stdin is a real pipe so that LocalExecutor hands it to PowerShell
+	// directly, rather than waiting on a copy that only ends with Close.
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter := io.Pipe()
+	stderrReader, stderrWriter := io.Pipe()
+
+	script := sessionHostScript(remoting)
+	command := &Command{
+		Path:   powershell.Path,
+		Args:   append(powerShellArgs(powershell.Edition), "-EncodedCommand", encodeCommand(stdinBootstrap)),
+		Script: script,
+		Stdin:  stdinReader,
+		Stdout: stdoutWriter,
+		Stderr: stderrWriter,
+	}
+
+	ps.logVerbose("starting PowerShell session", "path", command.Path, "args", commandArgs(command))
+
+	hostCtx, cancel := context.WithCancel(context.Background())
+	host := &sessionHost{
+		stdin:  stdinWriter,
+		stdout: make(chan string),
+		stderr: make(chan string),
+		exited: make(chan struct{}),
+		cancel: cancel,
+		marker: marker,
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go host.readLines(stdoutReader, host.stdout, &readers)
+	go host.readLines(stderrReader, host.stderr, &readers)
+	go func() {
+		defer cancel()
+
+		host.err = ps.executor().Run(hostCtx, command)
+		stdinReader.Close()
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		readers.Wait()
+		close(host.exited)
+	}()
+
+	if err := host.send(base64.StdEncoding.EncodeToString([]byte(script)) + "\n" + marker + "\n" + input); err != nil {
+		return nil, err
+	}
+
+	return host, nil
+}
+
+// readLines sends each line read from r to lines, which is closed at the
+// end of r.
+func (h *sessionHost) readLines(r io.Reader, lines chan<- string, readers *sync.WaitGroup) {
+	defer readers.Done()
+	defer close(lines)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes request to the host, killing it if that fails.
+func (h *sessionHost) send(request string) error {
+	if _, err := io.WriteString(h.stdin, request); err != nil {
+		h.kill()
+		if h.err != nil {
+			return h.ended()
+		}
+		return err
+	}
+	return nil
+}
+
+// receive copies the output of the current request until the marker on
+// both streams, returning the exit code that follows it on stdout.
+func (h *sessionHost) receive(ctx context.Context, stdout, stderr io.Writer) (int, error) {
+	stdoutLines, stderrLines := h.stdout, h.stderr
+	exitCode := 0
+
+	for stdoutLines != nil || stderrLines != nil {
+		select {
+		case line, ok := <-stdoutLines:
+			if !ok {
+				h.wait(nil)
+				return 0, h.ended()
+			}
+			if strings.HasPrefix(line, h.marker+" ") {
+				exitCode, _ = strconv.Atoi(line[len(h.marker)+1:])
+				stdoutLines = nil
+				continue
+			}
+			io.WriteString(stdout, line+"\n")
+		case line, ok := <-stderrLines:
+			if !ok {
+				h.wait(nil)
+				return 0, h.ended()
+			}
+			if line == h.marker {
+				stderrLines = nil
+				continue
+			}
+			io.WriteString(stderr, line+"\n")
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	return exitCode, nil
+}
+
+// kill stops the host and everything it started, and waits for it.
+func (h *sessionHost) kill() {
+	h.cancel()
+	h.stdin.Close()
+	h.wait(nil)
+}
+
+// ended describes a host that exited, once it has.
+func (h *sessionHost) ended() error {
+	if h.err != nil {
+		return fmt.Errorf("%w: %w", errSessionEnded, h.err)
+	}
+	return errSessionEnded
+}
+
+// wait discards output nobody asked for, so that the readers can finish,
+// until the host exits or timeout fires. It reports whether it exited.
+func (h *sessionHost) wait(timeout <-chan time.Time) bool {
+	stdout, stderr := h.stdout, h.stderr
+	for {
+		select {
+		case _, ok := <-stdout:
+			if !ok {
+				stdout = nil
+			}
+		case _, ok := <-stderr:
+			if !ok {
+				stderr = nil
+			}
+		case <-h.exited:
+			return true
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// sessionHostScript reads a marker, the credential and then one request
+// per line from stdin: a flag asking for $Session, the base64 encoded
+// script and its serialised parameters. Each request is followed by the
+// marker and the exit code on stdout, and by the marker alone on stderr.
+// remoting is the script from remotingScript.
+func sessionHostScript(remoting string) string {
+	return errorPreamble + credentialScript + `
+$ProgressPreference = 'SilentlyContinue'
+$psremoteMarker = [Console]::In.ReadLine()
+$psremoteCredential = Read-PSRemoteCredential
+` + remoting + `$Session = $null
+
+while ($null -ne ($psremoteLine = [Console]::In.ReadLine())) {
+	$psremoteRequest = $psremoteLine.Split(' ')
+	$psremoteExit = 0
+	try {
+		if ($psremoteRequest[0] -eq '1' -and (!$Session -or $Session.State -ne 'Opened')) {
+			if ($Session) {
+				Remove-PSSession -Session $Session -ErrorAction SilentlyContinue
+				$Session = $null
+			}
+			$Session = New-PSSession @psremoteArgs -ErrorAction Stop
+		}
+		$psremoteScript = [ScriptBlock]::Create([System.Text.Encoding]::UTF8.GetString([System.Convert]::FromBase64String($psremoteRequest[1])))
+		$global:LASTEXITCODE = 0
+		& $psremoteScript $psremoteRequest[2] 2>&1 3>&1 4>&1 5>&1 | Write-PSRemoteRecord | Out-String -Stream | ForEach-Object { [Console]::Out.WriteLine($_) }
+		if ($global:LASTEXITCODE) {
+			$psremoteExit = $global:LASTEXITCODE
+		}
+	} catch {
+		Write-PSRemoteError $_ $true
+		$psremoteExit = 1
+	}
+	[Console]::Out.WriteLine("$psremoteMarker $psremoteExit")
+	[Console]::Error.WriteLine($psremoteMarker)
+}
+
+if ($Session) {
+	Remove-PSSession -Session $Session
+}
+`
+}
+
+// newMarker returns a random token that a script cannot print by chance.
+func newMarker() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "psremote-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,256 @@
+package psremote
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionRequest is a request read by fakeSessionHost.
+type sessionRequest struct {
+	remote     bool
+	script     string
+	serialized string
+}
+
+// fakeSessionHost stands in for PowerShell running sessionHostScript,
+// answering each request with answer. It stops after requests, when
+// that is not zero, and exits with the code in the middle of a request
+// given a negative exit code.
+type fakeSessionHost struct {
+	answer   func(req sessionRequest) (stdout, stderr string, exitCode int)
+	requests int
+
+	mu       sync.Mutex
+	scripts  []string
+	received []sessionRequest
+	ended    chan struct{}
+}
+
+func newFakeSessionHost(answer func(req sessionRequest) (string, string, int)) *fakeSessionHost {
+	return &fakeSessionHost{answer: answer, ended: make(chan struct{}, 10)}
+}
+
+func (h *fakeSessionHost) handle(cmd *Command) FakeResponse {
+	defer func() { h.ended <- struct{}{} }()
+
+	stdin := bufio.NewReader(cmd.Stdin)
+	readLine := func() (string, bool) {
+		line, err := stdin.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err == nil
+	}
+
+	script, _ := readLine()
+	marker, _ := readLine()
+	readLine() // the credential
+
+	data, _ := base64.StdEncoding.DecodeString(script)
+	h.mu.Lock()
+	h.scripts = append(h.scripts, string(data))
+	h.mu.Unlock()
+
+	for n := 1; ; n++ {
+		line, ok := readLine()
+		if !ok {
+			return FakeResponse{}
+		}
+		fields := strings.Split(line, " ")
+		script, _ := base64.StdEncoding.DecodeString(fields[1])
+		req := sessionRequest{remote: fields[0] == "1", script: string(script), serialized: fields[2]}
+
+		h.mu.Lock()
+		h.received = append(h.received, req)
+		h.mu.Unlock()
+
+		stdout, stderr, exitCode := h.answer(req)
+		io.WriteString(cmd.Stdout, stdout)
+		if exitCode < 0 {
+			return FakeResponse{Err: &ExitError{Code: -exitCode}}
+		}
+		fmt.Fprintf(cmd.Stdout, "%s %d\n", marker, exitCode)
+		io.WriteString(cmd.Stderr, stderr)
+		fmt.Fprintln(cmd.Stderr, marker)
+
+		if n == h.requests {
+			return FakeResponse{}
+		}
+	}
+}
+
+func (h *fakeSessionHost) requestsReceived() []sessionRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]sessionRequest(nil), h.received...)
+}
+
+// waitEnded waits for a host run to return.
+func (h *fakeSessionHost) waitEnded(t *testing.T) {
+	select {
+	case <-h.ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session host did not end")
+	}
+}
+
+func newSessionTestRemote(host *fakeSessionHost) (*PSRemote, *FakeExecutor) {
+	fake := &FakeExecutor{Handler: host.handle}
+	return &PSRemote{
+		ComputerName:   "host",
+		UserName:       "admin",
+		Password:       "secret",
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+	}, fake
+}
+
+func TestSessionRequests(t *testing.T) {
+	host := newFakeSessionHost(func(req sessionRequest) (string, string, int) {
+		switch {
+		case strings.Contains(req.script, "exit 3"):
+			return "", "failed\n", 3
+		case req.script != paramPreamble:
+			return "web\r\n", "WARNING: slow\n", 0
+		}
+		return "", "", 0
+	})
+	ps, fake := newSessionTestRemote(host)
+
+	if err := ps.OpenSession(); err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	params := map[string]interface{}{"name": "web"}
+	result, err := ps.OutputWinRmResult("Get-VM $using:name", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "web" || len(result.Warnings) != 1 || result.Warnings[0] != "slow" {
+		t.Errorf("result %+v", result)
+	}
+
+	_, err = ps.Output("exit 3", nil)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.ExitCode != 3 {
+		t.Errorf("error %v, want exit code 3", err)
+	}
+
+	// One host served the empty script that opens the session and both
+	// calls, remote or local.
+	if n := len(fake.Calls()); n != 1 {
+		t.Fatalf("%d hosts started, want 1", n)
+	}
+	requests := host.requestsReceived()
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	if !requests[0].remote || requests[0].script != paramPreamble || requests[0].serialized != "" {
+		t.Errorf("opening request %+v", requests[0])
+	}
+	serialized, _ := serializeParams(params)
+	if !requests[1].remote || !strings.Contains(requests[1].script, "Get-VM $using:name") || requests[1].serialized != serialized {
+		t.Errorf("remote request %+v", requests[1])
+	}
+	if requests[2].remote || requests[2].script != paramPreamble+"exit 3" {
+		t.Errorf("local request %+v", requests[2])
+	}
+
+	remoting, _ := ps.remotingScript(context.Background())
+	if host.scripts[0] != sessionHostScript(remoting) {
+		t.Error("host was not sent sessionHostScript")
+	}
+	if call := fake.Calls()[0]; call.Script != host.scripts[0] {
+		t.Error("host command does not carry its script")
+	}
+}
+
+func TestSessionRestartsHost(t *testing.T) {
+	host := newFakeSessionHost(func(req sessionRequest) (string, string, int) {
+		return "ok\n", "", 0
+	})
+	host.requests = 2
+	ps, fake := newSessionTestRemote(host)
+
+	if err := ps.OpenSession(); err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	// The second request is the last the host answers before exiting,
+	// and the third is sent to a new one.
+	for i := 0; i < 2; i++ {
+		if out, err := ps.OutputWinRm("hostname", nil); err != nil || out != "ok" {
+			t.Fatalf("call %d: %q, %v", i, out, err)
+		}
+		if i == 0 {
+			host.waitEnded(t)
+		}
+	}
+
+	if n := len(fake.Calls()); n != 2 {
+		t.Errorf("%d hosts started, want 2", n)
+	}
+}
+
+func TestSessionHostEnds(t *testing.T) {
+	host := newFakeSessionHost(func(req sessionRequest) (string, string, int) {
+		if strings.Contains(req.script, "crash") {
+			return "partial\n", "", -5
+		}
+		return "ok\n", "", 0
+	})
+	ps, fake := newSessionTestRemote(host)
+
+	if err := ps.OpenSession(); err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+
+	_, err := ps.OutputWinRm("crash", nil)
+	var exitErr *ExitError
+	if !errors.Is(err, errSessionEnded) || !errors.As(err, &exitErr) || exitErr.Code != 5 {
+		t.Errorf("error %v, want the session ended with exit code 5", err)
+	}
+
+	if out, err := ps.OutputWinRm("hostname", nil); err != nil || out != "ok" {
+		t.Errorf("after the host ended: %q, %v", out, err)
+	}
+	if n := len(fake.Calls()); n != 2 {
+		t.Errorf("%d hosts started, want 2", n)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	host := newFakeSessionHost(func(req sessionRequest) (string, string, int) {
+		return "", "", 0
+	})
+	ps, fake := newSessionTestRemote(host)
+
+	if err := ps.OpenSession(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host saw the end of stdin and returned.
+	host.waitEnded(t)
+	if err := ps.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	// Calls no longer go through the session.
+	fake.Handler = nil
+	fake.Push("direct", "", nil)
+	if out, err := ps.OutputWinRm("hostname", nil); err != nil || out != "direct" {
+		t.Errorf("after Close: %q, %v", out, err)
+	}
+}
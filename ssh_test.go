@@ -0,0 +1,300 @@
+package psremote
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nimerix/psremote/sshtest"
+)
+
+func newSSHTestRemote(fake *FakeExecutor) *PSRemote {
+	return &PSRemote{
+		ComputerName:   "host.example",
+		UserName:       "o'brien",
+		Password:       "unused",
+		Port:           2222,
+		KeyFilePath:    `C:\keys\id_ed25519`,
+		Transport:      TransportSSH,
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+	}
+}
+
+func TestSSHRemotingArgs(t *testing.T) {
+	var stdin string
+	fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		data, _ := ioutil.ReadAll(cmd.Stdin)
+		stdin = string(data)
+		return FakeResponse{}
+	}}
+	ps := newSSHTestRemote(fake)
+
+	if _, err := ps.OutputWinRm("hostname", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := fake.LastCall()
+	for _, want := range []string{
+		"$psremoteArgs = @{ HostName = 'host.example' }",
+		"$psremoteArgs.UserName = 'o''brien'",
+		"$psremoteArgs.Port = 2222",
+		`$psremoteArgs.KeyFilePath = 'C:\keys\id_ed25519'`,
+		"Invoke-Command @psremoteArgs",
+	} {
+		if !strings.Contains(call.Script, want) {
+			t.Errorf("script does not contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{"@{ ComputerName", "UseSSL", "SessionOption", "unused"} {
+		if strings.Contains(call.Script, unwanted) {
+			t.Errorf("script contains %q", unwanted)
+		}
+	}
+
+	// ssh authenticates with keys, so no credential is sent.
+	if stdin != "\n" {
+		t.Errorf("stdin %q, want an empty credential line", stdin)
+	}
+}
+
+func TestSSHRemotingOptionalArgs(t *testing.T) {
+	fake := NewFakeExecutor()
+	ps := &PSRemote{ComputerName: "host", Transport: TransportSSH, PowerShellPath: "pwsh", Executor: fake}
+
+	if _, err := ps.OutputWinRm("hostname", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := fake.LastCall()
+	for _, unwanted := range []string{".UserName", ".Port", ".KeyFilePath"} {
+		if strings.Contains(call.Script, unwanted) {
+			t.Errorf("script sets %s", unwanted)
+		}
+	}
+}
+
+func TestSSHRemotingUserFromCredentials(t *testing.T) {
+	t.Setenv("PSREMOTE_TEST_SSH_USER", "deploy")
+
+	fake := NewFakeExecutor()
+	ps := newSSHTestRemote(fake)
+	ps.UserName = ""
+	ps.Credentials = EnvCredentials{UserNameVar: "PSREMOTE_TEST_SSH_USER"}
+
+	if _, err := ps.OutputSession("Copy-Item a -ToSession $Session", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	call, _ := fake.LastCall()
+	if !strings.Contains(call.Script, "$psremoteArgs.UserName = 'deploy'") {
+		t.Error("user name of the credential is not passed to ssh")
+	}
+	if !strings.Contains(call.Script, "New-PSSession @psremoteArgs") {
+		t.Error("session is not opened with the ssh arguments")
+	}
+}
+
+func TestSSHSessionHostScript(t *testing.T) {
+	ps := newSSHTestRemote(NewFakeExecutor())
+
+	remoting, err := ps.remotingScript(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := sessionHostScript(remoting)
+	if !strings.Contains(script, "HostName = 'host.example'") || !strings.Contains(script, "New-PSSession @psremoteArgs") {
+		t.Error("session host does not open the session over ssh")
+	}
+}
+
+func TestSSHNeedsPowerShellCore(t *testing.T) {
+	ps := newSSHTestRemote(NewFakeExecutor())
+	ps.PowerShellPath = `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`
+
+	if _, err := ps.OutputWinRm("hostname", nil); !errors.Is(err, ErrUnsupportedOption) {
+		t.Errorf("error %v for Windows PowerShell over TransportSSH, want ErrUnsupportedOption", err)
+	}
+}
+
+// TestSSHStandIn connects to the sshtest stand-in with the real pwsh and
+// ssh, when they are installed, and checks that it is reached with the
+// configured user, key and port.
+func TestSSHStandIn(t *testing.T) {
+	powershell, err := FindPowerShell(EditionCore)
+	if err != nil {
+		t.Skip(err)
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "psremote-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile, key, err := sshtest.WriteKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := sshtest.NewServer("alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// The stand-in's host key is new every run.
+	sshDir := filepath.Join(dir, ".ssh")
+	os.Mkdir(sshDir, 0700)
+	config := "Host *\n\tStrictHostKeyChecking no\n\tUserKnownHostsFile /dev/null\n\tBatchMode yes\n"
+	if err := ioutil.WriteFile(filepath.Join(sshDir, "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", dir)
+
+	ps := &PSRemote{
+		ComputerName:   srv.Host,
+		UserName:       "alice",
+		Port:           srv.Port,
+		KeyFilePath:    keyFile,
+		Transport:      TransportSSH,
+		PowerShellPath: powershell.Path,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The stand-in does not speak the remoting protocol, so the call
+	// fails once connected.
+	ps.OutputWinRmContext(ctx, "hostname", nil)
+
+	connections := srv.Connections()
+	if len(connections) == 0 {
+		t.Fatal("pwsh did not connect to the stand-in")
+	}
+	c := connections[0]
+	if c.UserName != "alice" {
+		t.Errorf("user %q, want alice", c.UserName)
+	}
+	if len(c.Subsystems) == 0 || c.Subsystems[0] != "powershell" {
+		t.Errorf("subsystems %v, want powershell", c.Subsystems)
+	}
+}
+
+var sshArgPattern = regexp.MustCompile(`\$psremoteArgs(?: = @\{ |\.)(\w+) = ('(?:[^']|'')*'|\d+)`)
+
+// sshStandInExecutor plays pwsh for TestSSHStandInArgs: it reads the ssh
+// arguments from the script and opens the PowerShell subsystem with them,
+// the way Invoke-Command -HostName does.
+func sshStandInExecutor() *FakeExecutor {
+	return &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		args := map[string]string{}
+		for _, m := range sshArgPattern.FindAllStringSubmatch(cmd.Script, -1) {
+			args[m[1]] = strings.ReplaceAll(strings.Trim(m[2], "'"), "''", "'")
+		}
+
+		pem, err := ioutil.ReadFile(args["KeyFilePath"])
+		if err != nil {
+			return FakeResponse{Err: err}
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return FakeResponse{Err: err}
+		}
+		client, err := ssh.Dial("tcp", net.JoinHostPort(args["HostName"], args["Port"]), &ssh.ClientConfig{
+			User:            args["UserName"],
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err != nil {
+			return FakeResponse{Stderr: err.Error() + "\n", Err: &ExitError{Code: 1}}
+		}
+		defer client.Close()
+
+		session, err := client.NewSession()
+		if err != nil {
+			return FakeResponse{Err: err}
+		}
+		defer session.Close()
+		if err := session.RequestSubsystem("powershell"); err != nil {
+			return FakeResponse{Err: err}
+		}
+		// The stand-in ends the subsystem at once.
+		session.Wait()
+		return FakeResponse{Stderr: "The background process reported an error\n", Err: &ExitError{Code: 1}}
+	}}
+}
+
+// TestSSHStandInArgs connects to the sshtest stand-in with the host,
+// port, user and key psremote passes to Invoke-Command, without needing
+// pwsh or ssh.
+func TestSSHStandInArgs(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, key, err := sshtest.WriteKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := sshtest.NewServer("o'brien", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	ps := &PSRemote{
+		ComputerName:   srv.Host,
+		UserName:       "o'brien",
+		Port:           srv.Port,
+		KeyFilePath:    keyFile,
+		Transport:      TransportSSH,
+		PowerShellPath: "pwsh",
+		Executor:       sshStandInExecutor(),
+	}
+	if _, err := ps.OutputWinRm("hostname", nil); err == nil {
+		t.Error("call succeeded against the stand-in")
+	}
+
+	connections := srv.Connections()
+	if len(connections) != 1 {
+		t.Fatalf("%d connections, want 1", len(connections))
+	}
+	c := connections[0]
+	if c.UserName != "o'brien" {
+		t.Errorf("user %q, want o'brien", c.UserName)
+	}
+	if c.KeyFingerprint != ssh.FingerprintSHA256(key) {
+		t.Errorf("connected with key %s, want %s", c.KeyFingerprint, ssh.FingerprintSHA256(key))
+	}
+	if len(c.Subsystems) != 1 || c.Subsystems[0] != "powershell" {
+		t.Errorf("subsystems %v, want [powershell]", c.Subsystems)
+	}
+
+	// A user the stand-in does not know is turned away.
+	mallory := &PSRemote{
+		ComputerName:   srv.Host,
+		UserName:       "mallory",
+		Port:           srv.Port,
+		KeyFilePath:    keyFile,
+		Transport:      TransportSSH,
+		PowerShellPath: "pwsh",
+		Executor:       sshStandInExecutor(),
+	}
+	if _, err := mallory.OutputWinRm("hostname", nil); err == nil {
+		t.Error("unknown user connected")
+	}
+	if n := len(srv.Connections()); n != 1 {
+		t.Errorf("%d connections, want 1", n)
+	}
+}
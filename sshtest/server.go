@@ -0,0 +1,200 @@
+// Package sshtest provides a local stand-in for an SSH server with the
+// PowerShell subsystem, for testing remoting over SSH without a host.
+//
+// The stand-in authenticates clients by public key and records each
+// connection and the subsystems it asks for. It does not speak the
+// PowerShell remoting protocol, so a session opened against it fails once
+// the subsystem is started.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection is an authenticated client connection.
+type Connection struct {
+	UserName string
+	// KeyFingerprint is the SHA256 fingerprint of the client's key.
+	KeyFingerprint string
+	// Subsystems are the subsystems requested, such as "powershell".
+	Subsystems []string
+}
+
+// Server is an SSH server listening on the loopback interface.
+type Server struct {
+	Host string
+	Port int
+
+	// UserName and AuthorizedKey are the only credentials accepted.
+	UserName      string
+	AuthorizedKey ssh.PublicKey
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu          sync.Mutex
+	connections []*Connection
+	wg          sync.WaitGroup
+}
+
+// NewServer starts a server accepting userName with authorizedKey.
+func NewServer(userName string, authorizedKey ssh.PublicKey) (*Server, error) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		UserName:      userName,
+		AuthorizedKey: authorizedKey,
+		listener:      listener,
+	}
+	s.config = &ssh.ServerConfig{PublicKeyCallback: s.authenticate}
+	s.config.AddHostKey(signer)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	s.Host = host
+	s.Port, _ = strconv.Atoi(port)
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and waits for its connections to end.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Connections returns the authenticated connections so far, in order.
+func (s *Server) Connections() []Connection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	connections := make([]Connection, len(s.connections))
+	for i, c := range s.connections {
+		connections[i] = *c
+		connections[i].Subsystems = append([]string(nil), c.Subsystems...)
+	}
+	return connections
+}
+
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if meta.User() != s.UserName || s.AuthorizedKey == nil || ssh.FingerprintSHA256(key) != ssh.FingerprintSHA256(s.AuthorizedKey) {
+		return nil, fmt.Errorf("unknown key for %s", meta.User())
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"fingerprint": ssh.FingerprintSHA256(key)}}, nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	c := &Connection{
+		UserName:       serverConn.User(),
+		KeyFingerprint: serverConn.Permissions.Extensions["fingerprint"],
+	}
+	s.mu.Lock()
+	s.connections = append(s.connections, c)
+	s.mu.Unlock()
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(c, channel, requests)
+	}
+}
+
+// session records subsystem requests, then ends the session as if the
+// subsystem had failed.
+func (s *Server) session(c *Connection, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "subsystem" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Name string }
+		ssh.Unmarshal(req.Payload, &payload)
+		s.mu.Lock()
+		c.Subsystems = append(c.Subsystems, payload.Name)
+		s.mu.Unlock()
+
+		req.Reply(true, nil)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+		return
+	}
+}
+
+// WriteKey generates a client key, writes its private half to dir in
+// OpenSSH format for ssh -i, and returns the file and the public key.
+func WriteKey(dir string) (string, ssh.PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	path := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return "", nil, err
+	}
+
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, key, nil
+}
@@ -0,0 +1,96 @@
+package sshtest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// runSSH starts the PowerShell subsystem on srv with the ssh client, as
+// Invoke-Command -HostName does.
+func runSSH(srv *Server, user, keyFile string) error {
+	cmd := exec.Command("ssh",
+		"-F", "/dev/null",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "BatchMode=yes",
+		"-o", "IdentitiesOnly=yes",
+		"-i", keyFile,
+		"-l", user,
+		"-p", strconv.Itoa(srv.Port),
+		"-s", srv.Host, "powershell")
+	return cmd.Run()
+}
+
+func newTestServer(t *testing.T) (*Server, string, string) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "sshtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	keyFile, key, err := WriteKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewServer("alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	return srv, dir, keyFile
+}
+
+func TestSubsystem(t *testing.T) {
+	srv, _, keyFile := newTestServer(t)
+
+	// The stand-in ends the subsystem with status 1.
+	if err := runSSH(srv, "alice", keyFile); err == nil {
+		t.Error("subsystem succeeded")
+	}
+
+	connections := srv.Connections()
+	if len(connections) != 1 {
+		t.Fatalf("%d connections, want 1", len(connections))
+	}
+	c := connections[0]
+	if c.UserName != "alice" {
+		t.Errorf("user %q, want alice", c.UserName)
+	}
+	if c.KeyFingerprint != ssh.FingerprintSHA256(srv.AuthorizedKey) {
+		t.Errorf("fingerprint %s, want %s", c.KeyFingerprint, ssh.FingerprintSHA256(srv.AuthorizedKey))
+	}
+	if len(c.Subsystems) != 1 || c.Subsystems[0] != "powershell" {
+		t.Errorf("subsystems %v, want [powershell]", c.Subsystems)
+	}
+}
+
+func TestAuthenticationFailure(t *testing.T) {
+	srv, dir, keyFile := newTestServer(t)
+
+	if err := runSSH(srv, "mallory", keyFile); err == nil {
+		t.Error("unknown user was accepted")
+	}
+
+	otherDir, _ := ioutil.TempDir(dir, "other")
+	otherKey, _, err := WriteKey(otherDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runSSH(srv, "alice", otherKey); err == nil {
+		t.Error("unknown key was accepted")
+	}
+
+	if n := len(srv.Connections()); n != 0 {
+		t.Errorf("%d connections authenticated, want 0", n)
+	}
+}
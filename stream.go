@@ -0,0 +1,151 @@
+package psremote
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Stream identifies the PowerShell stream a line of output came from.
+type Stream int
+
+const (
+	StreamOutput Stream = iota
+	StreamError
+	StreamWarning
+	StreamVerbose
+	StreamDebug
+	StreamProgress
+	StreamInformation
+)
+
+var streamNames = [...]string{
+	StreamOutput:      "output",
+	StreamError:       "error",
+	StreamWarning:     "warning",
+	StreamVerbose:     "verbose",
+	StreamDebug:       "debug",
+	StreamProgress:    "progress",
+	StreamInformation: "information",
+}
+
+func (s Stream) String() string {
+	if s >= 0 && int(s) < len(streamNames) {
+		return streamNames[s]
+	}
+	return "unknown"
+}
+
+// streamOf maps a CLIXML stream name to a Stream.
+func streamOf(name string) Stream {
+	for s, n := range streamNames {
+		if n == name {
+			return Stream(s)
+		}
+	}
+	return StreamError
+}
+
+// outputWriter collects what a script writes to one of stdout or stderr,
+// copying each complete line to tee and handing it to line as it arrives.
+type outputWriter struct {
+	mu      *sync.Mutex
+	buf     bytes.Buffer
+	tee     io.Writer
+	line    func(string)
+	partial []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+
+	if w.tee == nil && w.line == nil {
+		return len(p), nil
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.partial[:i+1])
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// emit copies a line, with its line ending, to tee and line.
+func (w *outputWriter) emit(raw []byte) {
+	if w.tee != nil {
+		w.tee.Write(raw)
+	}
+	if w.line != nil {
+		w.line(strings.TrimRight(string(raw), "\r\n"))
+	}
+}
+
+// flush hands over the last line when it was not terminated, ending it
+// so that the output of the next call starts on a line of its own.
+func (w *outputWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.emit(append(w.partial, '\n'))
+		w.partial = nil
+	}
+}
+
+func (w *outputWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.buf.String()
+}
+
+// outputWriters returns the writers for a script's stdout and stderr. They
+// collect the output of this call only, but share a lock with every other
+// call so that Stdout, Stderr and OnLine are never used concurrently.
+// OnLine runs with the lock held, which is why it cannot make calls.
+func (ps *PSRemote) outputWriters() (stdout, stderr *outputWriter) {
+	stdout = &outputWriter{mu: &ps.outputMu, tee: ps.Stdout}
+	stderr = &outputWriter{mu: &ps.outputMu, tee: ps.Stderr}
+
+	if ps.OnLine != nil {
+		onLine := ps.OnLine
+		stdout.line = func(line string) { onLine(StreamOutput, line) }
+		stderr.line = (&stderrLines{onLine: onLine}).line
+	}
+
+	return stdout, stderr
+}
+
+// stderrLines sorts stderr lines into streams as they arrive. PowerShell
+// may spread a CLIXML document over several lines, which are held back
+// until it is complete.
+type stderrLines struct {
+	onLine  func(Stream, string)
+	pending string
+}
+
+func (s *stderrLines) line(line string) {
+	if s.pending != "" {
+		line = s.pending + "\n" + line
+		s.pending = ""
+	}
+
+	if strings.HasPrefix(line, clixmlHeader) && !strings.Contains(line, "</Objs>") {
+		s.pending = line
+		return
+	}
+
+	for _, r := range parseStderr(line) {
+		s.onLine(streamOf(r.stream), r.text)
+	}
+}
@@ -0,0 +1,112 @@
+package psremote
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type streamLine struct {
+	stream Stream
+	line   string
+}
+
+func TestOnLine(t *testing.T) {
+	// The CLIXML document arrives over several lines, and the last line
+	// of stdout is not terminated.
+	fake := NewFakeExecutor(FakeResponse{
+		Stdout: "first\r\nsecond",
+		Stderr: "WARNING: low disk\n" + strings.Replace(streamsSample, "<Obj S=", "\n<Obj S=", 1) + "plain error\n",
+	})
+
+	var lines []streamLine
+	var stdout strings.Builder
+	ps := &PSRemote{
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+		Stdout:         &stdout,
+		OnLine: func(stream Stream, line string) {
+			lines = append(lines, streamLine{stream, line})
+		},
+	}
+
+	// The plain error line fails the call.
+	ps.OutputResult("'x'", nil)
+
+	want := []streamLine{
+		{StreamOutput, "first"},
+		{StreamWarning, "low disk"},
+		{StreamProgress, ""},
+		{StreamWarning, "The VM is already running."},
+		{StreamVerbose, "Starting web01."},
+		{StreamDebug, "state=2"},
+		{StreamError, "plain error"},
+		// The unterminated line is handed over when the call ends.
+		{StreamOutput, "second"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines\n %v\nwant\n %v", lines, want)
+	}
+	if stdout.String() != "first\r\nsecond\n" {
+		t.Errorf("Stdout %q", stdout.String())
+	}
+}
+
+func TestOnLineNotConcurrent(t *testing.T) {
+	fake := &FakeExecutor{Handler: func(cmd *Command) FakeResponse {
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(cmd.Stdout, "line %d\n", i)
+			fmt.Fprintf(cmd.Stderr, "WARNING: line %d\n", i)
+		}
+		return FakeResponse{}
+	}}
+
+	var running, overlaps, count int32
+	ps := &PSRemote{
+		PowerShellPath: "pwsh",
+		Executor:       fake,
+		OnLine: func(stream Stream, line string) {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			atomic.AddInt32(&count, 1)
+			atomic.AddInt32(&running, -1)
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ps.Output("'x'", nil)
+		}()
+	}
+	wg.Wait()
+
+	if overlaps != 0 {
+		t.Errorf("OnLine was called concurrently %d times", overlaps)
+	}
+	if count != 8*40 {
+		t.Errorf("%d lines, want %d", count, 8*40)
+	}
+}
+
+func TestStreamString(t *testing.T) {
+	for stream, want := range map[Stream]string{
+		StreamOutput:      "output",
+		StreamInformation: "information",
+		Stream(-1):        "unknown",
+		Stream(99):        "unknown",
+	} {
+		if got := stream.String(); got != want {
+			t.Errorf("Stream(%d) = %s, want %s", int(stream), got, want)
+		}
+	}
+	if streamOf("warning") != StreamWarning || streamOf("something") != StreamError {
+		t.Error("streamOf does not map CLIXML stream names")
+	}
+}
@@ -0,0 +1,316 @@
+package psremote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrUnexpectedCommand is returned by ReplayExecutor for a command that is
+// not in its transcript, such as a script that changed since it was
+// recorded.
+var ErrUnexpectedCommand = errors.New("command not in transcript")
+
+// TranscriptVersion is the format version written to transcript files.
+const TranscriptVersion = 1
+
+// Transcript is a recording of the commands run by PSRemote and their
+// results, for replaying with ReplayExecutor.
+//
+// Scripts and parameters are recorded as they were run. Credentials are
+// passed on stdin and never recorded, but secrets passed as parameters
+// are, so record against test hosts.
+type Transcript struct {
+	Version int               `json:"version"`
+	Entries []TranscriptEntry `json:"entries"`
+}
+
+// TranscriptEntry is one recorded command.
+type TranscriptEntry struct {
+	// Script is the full generated script, see Command.Script.
+	Script string                 `json:"script"`
+	Params map[string]interface{} `json:"params,omitempty"`
+	Stdout string                 `json:"stdout"`
+	Stderr string                 `json:"stderr"`
+	// ExitCode is the exit code of a script that ran, and Error the
+	// message of any other failure to run it.
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// LoadTranscript reads a transcript file written by Recorder.
+func LoadTranscript(path string) (*Transcript, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var transcript Transcript
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		return nil, fmt.Errorf("transcript %s: %w", path, err)
+	}
+	if transcript.Version != TranscriptVersion {
+		return nil, fmt.Errorf("transcript %s: unsupported version %d", path, transcript.Version)
+	}
+	return &transcript, nil
+}
+
+// Save writes t to path as indented JSON, readable only by its owner since
+// parameters may hold secrets.
+func (t *Transcript) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(path, 0600)
+}
+
+// Recorder records every command run by the PSRemote it is set on, with
+// the output and exit code it produced. The commands still run, through
+// Executor or the transport's default.
+//
+// Commands run through a session opened with OpenSession are not
+// recorded.
+type Recorder struct {
+	// Path, when set, is the transcript file. It is rewritten after every
+	// command, so that it is complete even if the program stops.
+	Path string
+
+	mu         sync.Mutex
+	transcript Transcript
+}
+
+// NewRecorder returns a Recorder writing its transcript to path.
+func NewRecorder(path string) *Recorder {
+	return &Recorder{Path: path}
+}
+
+// Transcript returns the commands recorded so far.
+func (r *Recorder) Transcript() *Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := make([]TranscriptEntry, len(r.transcript.Entries))
+	copy(entries, r.transcript.Entries)
+	return &Transcript{Version: TranscriptVersion, Entries: entries}
+}
+
+func (r *Recorder) record(entry TranscriptEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.transcript.Version = TranscriptVersion
+	r.transcript.Entries = append(r.transcript.Entries, entry)
+	if r.Path == "" {
+		return nil
+	}
+	return r.transcript.Save(r.Path)
+}
+
+// recordingExecutor runs commands through executor and records them to
+// recorder.
+type recordingExecutor struct {
+	recorder *Recorder
+	executor Executor
+}
+
+func (e recordingExecutor) Run(ctx context.Context, cmd *Command) error {
+	var stdout, stderr bytes.Buffer
+
+	recorded := *cmd
+	recorded.Stdout = teeWriter(cmd.Stdout, &stdout)
+	recorded.Stderr = teeWriter(cmd.Stderr, &stderr)
+
+	err := e.executor.Run(ctx, &recorded)
+
+	// A cancelled command did not produce a result worth replaying.
+	if ctx.Err() != nil {
+		return err
+	}
+
+	entry := TranscriptEntry{
+		Script: cmd.Script,
+		Params: cmd.Params,
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+		entry.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if recordErr := e.recorder.record(entry); recordErr != nil {
+		return fmt.Errorf("recording transcript: %w", recordErr)
+	}
+	return err
+}
+
+func teeWriter(w io.Writer, buf *bytes.Buffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+// ReplayExecutor is an Executor that answers from a transcript instead of
+// running anything. Each command must match the script and parameters of
+// a recorded entry, and each entry is served once, so that any change to
+// the generated scripts fails with ErrUnexpectedCommand.
+type ReplayExecutor struct {
+	// Ordered requires commands in the order they were recorded.
+	// Otherwise the first unused matching entry is served, which suits
+	// callers that run commands concurrently, such as HostGroup.
+	Ordered bool
+
+	mu      sync.Mutex
+	entries []TranscriptEntry
+	used    []bool
+}
+
+// NewReplayExecutor returns a ReplayExecutor serving the entries of
+// transcript.
+func NewReplayExecutor(transcript *Transcript) *ReplayExecutor {
+	return &ReplayExecutor{
+		entries: transcript.Entries,
+		used:    make([]bool, len(transcript.Entries)),
+	}
+}
+
+func (r *ReplayExecutor) Run(ctx context.Context, cmd *Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entry, err := r.next(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := writeString(cmd.Stdout, entry.Stdout); err != nil {
+		return err
+	}
+	if err := writeString(cmd.Stderr, entry.Stderr); err != nil {
+		return err
+	}
+
+	switch {
+	case entry.Error != "":
+		return errors.New(entry.Error)
+	case entry.ExitCode != 0:
+		return &ExitError{Code: entry.ExitCode}
+	}
+	return nil
+}
+
+// next marks the entry matching cmd as used and returns it.
+func (r *ReplayExecutor) next(cmd *Command) (TranscriptEntry, error) {
+	params, err := normalizeParams(cmd.Params)
+	if err != nil {
+		return TranscriptEntry{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nearest := -1
+	for i, entry := range r.entries {
+		if r.used[i] {
+			continue
+		}
+		if nearest < 0 {
+			nearest = i
+		}
+
+		if entry.Script == cmd.Script {
+			recorded, err := normalizeParams(entry.Params)
+			if err != nil {
+				return TranscriptEntry{}, err
+			}
+			if recorded == params {
+				r.used[i] = true
+				return entry, nil
+			}
+		}
+
+		if r.Ordered {
+			break
+		}
+	}
+
+	if nearest < 0 {
+		return TranscriptEntry{}, fmt.Errorf("%w: all %d entries were used", ErrUnexpectedCommand, len(r.entries))
+	}
+
+	expected := r.entries[nearest]
+	if expected.Script != cmd.Script {
+		return TranscriptEntry{}, fmt.Errorf("%w: script differs from entry %d, %s", ErrUnexpectedCommand, nearest, firstDifference(expected.Script, cmd.Script))
+	}
+	recorded, _ := normalizeParams(expected.Params)
+	return TranscriptEntry{}, fmt.Errorf("%w: params %s differ from entry %d, which has %s", ErrUnexpectedCommand, params, nearest, recorded)
+}
+
+// Unused returns the entries that have not been served, which a complete
+// replay leaves empty.
+func (r *ReplayExecutor) Unused() []TranscriptEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []TranscriptEntry
+	for i, entry := range r.entries {
+		if !r.used[i] {
+			unused = append(unused, entry)
+		}
+	}
+	return unused
+}
+
+// normalizeParams encodes params as JSON, so that parameters compare equal
+// to their values read back from a transcript file.
+func normalizeParams(params map[string]interface{}) (string, error) {
+	if len(params) == 0 {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(decoded)
+	return string(data), err
+}
+
+// firstDifference describes the first line where got differs from want.
+func firstDifference(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("line %d is %q, recorded %q", i+1, g, w)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package psremote
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the transcripts in testdata")
+
+// transcriptFixture is synthetic: it is recorded with -update against
+// hostResponses, not against a real host, so it pins the generated
+// scripts but its outputs are only what hostResponses makes up.
+const transcriptFixture = "testdata/synthetic_transcript.json"
+
+func newTranscriptRemote(executor Executor) *PSRemote {
+	return &PSRemote{
+		ComputerName:   "hyperv01",
+		UserName:       "admin",
+		Password:       "secret",
+		PowerShellPath: "pwsh",
+		Executor:       executor,
+	}
+}
+
+// hostResponses stands in for hyperv01 when the fixture is recorded. Its
+// answers, the exit code of "exit 1" among them, are made up rather than
+// taken from a host.
+func hostResponses(cmd *Command) FakeResponse {
+	switch {
+	case strings.Contains(cmd.Script, "Get-Service"):
+		return FakeResponse{Stdout: "Running\n"}
+	case strings.Contains(cmd.Script, "Get-VM"):
+		return FakeResponse{Stdout: `[{"Name":"web","State":2}]` + "\n"}
+	}
+	return FakeResponse{Err: &ExitError{Code: 1}}
+}
+
+// runTranscriptCalls makes the calls recorded in the fixture.
+func runTranscriptCalls(t *testing.T, ps *PSRemote) {
+	status, err := ps.OutputWinRm("Get-Service $using:name | Select-Object -ExpandProperty Status", map[string]interface{}{"name": "WinRM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "Running" {
+		t.Errorf("status %q, want Running", status)
+	}
+
+	var vms []struct {
+		Name  string
+		State int
+	}
+	if err := ps.OutputWinRmJSON("Get-VM | Select-Object Name, State", nil, &vms); err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 1 || vms[0].Name != "web" || vms[0].State != 2 {
+		t.Errorf("virtual machines %+v, want web in state 2", vms)
+	}
+
+	_, err = ps.OutputWinRm("exit 1", nil)
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) || scriptErr.ExitCode != 1 {
+		t.Errorf("error %v, want a *ScriptError with exit code 1", err)
+	}
+}
+
+func TestTranscriptReplay(t *testing.T) {
+	if *update {
+		recorder := NewRecorder(transcriptFixture)
+		ps := newTranscriptRemote(&FakeExecutor{Handler: hostResponses})
+		ps.Recorder = recorder
+		runTranscriptCalls(t, ps)
+	}
+
+	transcript, err := LoadTranscript(transcriptFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range transcript.Entries {
+		if strings.Contains(entry.Script, "secret") {
+			t.Fatal("the password was recorded")
+		}
+	}
+
+	replay := NewReplayExecutor(transcript)
+	replay.Ordered = true
+	runTranscriptCalls(t, newTranscriptRemote(replay))
+
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("%d entries were not replayed", len(unused))
+	}
+}
+
+func TestTranscriptReplayChangedScript(t *testing.T) {
+	transcript, err := LoadTranscript(transcriptFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := newTranscriptRemote(NewReplayExecutor(transcript))
+
+	_, err = ps.OutputWinRm("Get-Service $using:name | Select-Object -ExpandProperty StartType", map[string]interface{}{"name": "WinRM"})
+	if !errors.Is(err, ErrUnexpectedCommand) {
+		t.Errorf("error %v, want ErrUnexpectedCommand", err)
+	}
+
+	_, err = ps.OutputWinRm("Get-Service $using:name | Select-Object -ExpandProperty Status", map[string]interface{}{"name": "WinRM", "extra": 1})
+	if !errors.Is(err, ErrUnexpectedCommand) {
+		t.Errorf("error %v for changed params, want ErrUnexpectedCommand", err)
+	}
+}
+
+func TestTranscriptSaveMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "psremote-transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An existing file is made private too.
+	path := filepath.Join(dir, "transcript.json")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := &Transcript{Version: TranscriptVersion}
+	if err := transcript.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode %o, want 600", mode)
+	}
+}
@@ -0,0 +1,266 @@
+package unattend
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Element is one XML element of an answer file. Name.Space holds the
+// namespace URI, not the prefix. Attr keeps namespace declarations as read
+// so that a parsed file is written back with the same prefixes.
+//
+// An element has either Text or Children. Comments are not kept.
+type Element struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Text     string
+	Children []*Element
+}
+
+// NewElement returns an empty element called local in the unattend
+// namespace.
+func NewElement(local string) *Element {
+	return &Element{Name: xml.Name{Space: Namespace, Local: local}}
+}
+
+// Child returns the first child called local in the unattend namespace, or
+// nil.
+func (e *Element) Child(local string) *Element {
+	for _, child := range e.Children {
+		if child.Name.Space == Namespace && child.Name.Local == local {
+			return child
+		}
+	}
+	return nil
+}
+
+// ChildrenNamed returns every child called local in the unattend
+// namespace.
+func (e *Element) ChildrenNamed(local string) []*Element {
+	var children []*Element
+	for _, child := range e.Children {
+		if child.Name.Space == Namespace && child.Name.Local == local {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Find follows path, one child name per step, and returns the element at
+// its end or nil.
+func (e *Element) Find(path ...string) *Element {
+	for _, local := range path {
+		if e = e.Child(local); e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+// Ensure is like Find but creates the elements that are missing.
+func (e *Element) Ensure(path ...string) *Element {
+	for _, local := range path {
+		child := e.Child(local)
+		if child == nil {
+			child = e.Add(local)
+		}
+		e = child
+	}
+	return e
+}
+
+// Add appends a new child called local and returns it.
+func (e *Element) Add(local string) *Element {
+	child := NewElement(local)
+	e.Children = append(e.Children, child)
+	return child
+}
+
+// SetText replaces everything below e with text.
+func (e *Element) SetText(text string) *Element {
+	e.Text = text
+	e.Children = nil
+	return e
+}
+
+// Remove deletes every child called local and reports whether there was
+// one.
+func (e *Element) Remove(local string) bool {
+	children := e.Children[:0]
+	for _, child := range e.Children {
+		if child.Name.Space != Namespace || child.Name.Local != local {
+			children = append(children, child)
+		}
+	}
+	removed := len(children) != len(e.Children)
+	e.Children = children
+	return removed
+}
+
+// Attribute returns the value of the attribute space:local, where space is
+// a namespace URI or "" for an unqualified attribute.
+func (e *Element) Attribute(space, local string) string {
+	for _, attr := range e.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// SetAttribute sets the attribute space:local to value.
+func (e *Element) SetAttribute(space, local, value string) *Element {
+	for i, attr := range e.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			e.Attr[i].Value = value
+			return e
+		}
+	}
+	e.Attr = append(e.Attr, xml.Attr{Name: xml.Name{Space: space, Local: local}, Value: value})
+	return e
+}
+
+// parseElement reads the element started by start and everything below
+// it.
+func parseElement(d *xml.Decoder, start xml.StartElement) (*Element, error) {
+	e := &Element{Name: start.Name, Attr: start.Attr}
+
+	var text strings.Builder
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := parseElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			e.Children = append(e.Children, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(e.Children) == 0 {
+				e.Text = text.String()
+			}
+			return e, nil
+		}
+	}
+}
+
+// Only what must be is escaped, so that command lines stay readable.
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// writer writes elements with four space indentation, as Windows System
+// Image Manager does, choosing a prefix for every namespace in scope.
+type writer struct {
+	b strings.Builder
+}
+
+// element writes e at depth. prefixes maps the namespace URIs declared by
+// e's ancestors to their prefix, "" for the default namespace.
+func (w *writer) element(e *Element, depth int, prefixes map[string]string) {
+	scope := make(map[string]string, len(prefixes))
+	for uri, prefix := range prefixes {
+		scope[uri] = prefix
+	}
+
+	for _, attr := range e.Attr {
+		if isDeclaration(attr) {
+			scope[attr.Value] = attr.Name.Local
+			if attr.Name.Space == "" {
+				scope[attr.Value] = ""
+			}
+		}
+	}
+
+	// Elements created here, rather than parsed, may use a namespace that
+	// nothing declares yet. Declarations it needs are added after the
+	// attributes.
+	var declarations []xml.Attr
+	name := qualify(e.Name.Space, e.Name.Local, scope, &declarations, true)
+
+	var attrs []xml.Attr
+	for _, attr := range e.Attr {
+		if !isDeclaration(attr) {
+			attr.Name = xml.Name{Local: qualify(attr.Name.Space, attr.Name.Local, scope, &declarations, false)}
+		}
+		attrs = append(attrs, attr)
+	}
+	attrs = append(attrs, declarations...)
+
+	indent := strings.Repeat("    ", depth)
+	w.b.WriteString(indent + "<" + name)
+	for _, attr := range attrs {
+		w.b.WriteString(" " + attrString(attr) + `="` + attrEscaper.Replace(attr.Value) + `"`)
+	}
+
+	switch {
+	case len(e.Children) > 0:
+		w.b.WriteString(">\n")
+		for _, child := range e.Children {
+			w.element(child, depth+1, scope)
+		}
+		w.b.WriteString(indent + "</" + name + ">\n")
+	case e.Text != "":
+		w.b.WriteString(">" + textEscaper.Replace(e.Text) + "</" + name + ">\n")
+	default:
+		w.b.WriteString(" />\n")
+	}
+}
+
+// qualify returns the prefixed name for space:local, declaring the
+// namespace in declarations when it is not in scope.
+func qualify(space, local string, scope map[string]string, declarations *[]xml.Attr, isElement bool) string {
+	if space == "" {
+		return local
+	}
+
+	prefix, ok := scope[space]
+	if ok && (prefix != "" || isElement) {
+		if prefix == "" {
+			return local
+		}
+		return prefix + ":" + local
+	}
+
+	prefix = knownPrefixes[space]
+	if prefix == "" && isElement {
+		scope[space] = ""
+		*declarations = append(*declarations, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: space})
+		return local
+	}
+	for i := 0; prefix == "" || prefixTaken(scope, prefix); i++ {
+		prefix = "ns" + strconv.Itoa(i)
+	}
+
+	scope[space] = prefix
+	*declarations = append(*declarations, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: space})
+	return prefix + ":" + local
+}
+
+func prefixTaken(scope map[string]string, prefix string) bool {
+	for _, p := range scope {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func isDeclaration(attr xml.Attr) bool {
+	return attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns"
+}
+
+func attrString(attr xml.Attr) string {
+	if attr.Name.Space == "" {
+		return attr.Name.Local
+	}
+	return attr.Name.Space + ":" + attr.Name.Local
+}
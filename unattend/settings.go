@@ -0,0 +1,351 @@
+package unattend
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Components edited by the methods below.
+const (
+	ComponentSetup                  = "Microsoft-Windows-Setup"
+	ComponentShellSetup             = "Microsoft-Windows-Shell-Setup"
+	ComponentInternationalCore      = "Microsoft-Windows-International-Core"
+	ComponentInternationalCoreWinPE = "Microsoft-Windows-International-Core-WinPE"
+)
+
+// Encoded passwords have the name of their element appended before
+// encoding.
+const (
+	administratorPasswordSuffix = "AdministratorPassword"
+	autoLogonPasswordSuffix     = "Password"
+)
+
+// SetProductKey sets the product key applied in the specialize pass. When
+// the file installs Windows from windowsPE the key used by Setup is set
+// too.
+func (d *Document) SetProductKey(key string) {
+	d.EnsureComponent(PassSpecialize, ComponentShellSetup).Ensure("ProductKey").SetText(key)
+
+	if setup := d.Component(PassWindowsPE, ComponentSetup); setup != nil {
+		setup.Ensure("UserData", "ProductKey", "Key").SetText(key)
+	}
+}
+
+// ProductKey returns the product key applied in the specialize pass, or
+// the one used by Setup when there is none.
+func (d *Document) ProductKey() string {
+	if shell := d.Component(PassSpecialize, ComponentShellSetup); shell != nil {
+		if key := shell.Child("ProductKey"); key != nil {
+			return strings.TrimSpace(key.Text)
+		}
+	}
+	if setup := d.Component(PassWindowsPE, ComponentSetup); setup != nil {
+		if key := setup.Find("UserData", "ProductKey", "Key"); key != nil {
+			return strings.TrimSpace(key.Text)
+		}
+	}
+	return ""
+}
+
+// SetAdministratorPassword sets the built-in Administrator's password in
+// the oobeSystem pass. The password is stored encoded, as Windows System
+// Image Manager does when hiding passwords, which keeps it from casual
+// view but is not encryption.
+func (d *Document) SetAdministratorPassword(password string) {
+	setPassword(d.EnsureComponent(PassOOBESystem, ComponentShellSetup).Ensure("UserAccounts", "AdministratorPassword"),
+		password, administratorPasswordSuffix)
+}
+
+// AdministratorPassword returns the built-in Administrator's password,
+// decoding it when it is not stored as plain text.
+func (d *Document) AdministratorPassword() (string, error) {
+	shell := d.Component(PassOOBESystem, ComponentShellSetup)
+	if shell == nil {
+		return "", nil
+	}
+	return getPassword(shell.Find("UserAccounts", "AdministratorPassword"), administratorPasswordSuffix)
+}
+
+// SetAutoLogon logs userName on automatically count times after setup, so
+// that first logon commands run without anyone logging on. A count of
+// zero logs on once.
+func (d *Document) SetAutoLogon(userName, password string, count int) {
+	if count <= 0 {
+		count = 1
+	}
+
+	autoLogon := d.EnsureComponent(PassOOBESystem, ComponentShellSetup).Ensure("AutoLogon")
+	autoLogon.Ensure("Enabled").SetText("true")
+	autoLogon.Ensure("Username").SetText(userName)
+	autoLogon.Ensure("LogonCount").SetText(strconv.Itoa(count))
+	setPassword(autoLogon.Ensure("Password"), password, autoLogonPasswordSuffix)
+}
+
+// Locale is the language and regional settings. Empty fields take
+// UILanguage.
+type Locale struct {
+	UILanguage   string
+	InputLocale  string
+	SystemLocale string
+	UserLocale   string
+}
+
+// SetLocale sets the locale of the installed Windows in the oobeSystem
+// pass, and of Setup itself when the file has a windowsPE pass.
+func (d *Document) SetLocale(locale Locale) {
+	if locale.InputLocale == "" {
+		locale.InputLocale = locale.UILanguage
+	}
+	if locale.SystemLocale == "" {
+		locale.SystemLocale = locale.UILanguage
+	}
+	if locale.UserLocale == "" {
+		locale.UserLocale = locale.UILanguage
+	}
+
+	setLocale(d.EnsureComponent(PassOOBESystem, ComponentInternationalCore), locale)
+
+	if d.Settings(PassWindowsPE) != nil {
+		winPE := d.EnsureComponent(PassWindowsPE, ComponentInternationalCoreWinPE)
+		setLocale(winPE, locale)
+		winPE.Ensure("SetupUILanguage", "UILanguage").SetText(locale.UILanguage)
+	}
+}
+
+func setLocale(component *Element, locale Locale) {
+	component.Ensure("InputLocale").SetText(locale.InputLocale)
+	component.Ensure("SystemLocale").SetText(locale.SystemLocale)
+	component.Ensure("UILanguage").SetText(locale.UILanguage)
+	component.Ensure("UserLocale").SetText(locale.UserLocale)
+}
+
+// Partition is one partition created on a Disk. Partitions are numbered
+// from 1 in the order given.
+type Partition struct {
+	// Type is Primary, EFI, MSR or Extended.
+	Type string
+	// SizeMB is the size of the partition. It is ignored when Extend is
+	// set.
+	SizeMB int
+	// Extend makes the partition fill the rest of the disk.
+	Extend bool
+	// Format is NTFS or FAT32. An empty Format leaves the partition
+	// unformatted.
+	Format string
+	Label  string
+	Letter string
+	Active bool
+}
+
+// Disk is the layout Setup creates on one disk.
+type Disk struct {
+	ID           int
+	WillWipeDisk bool
+	Partitions   []Partition
+}
+
+// InstallTo is the partition Windows is installed to.
+type InstallTo struct {
+	DiskID      int
+	PartitionID int
+}
+
+// DiskForGeneration returns the usual layout for a Hyper-V VM generation:
+// a system reserved and a Windows partition for generation 1, which boots
+// from BIOS, and EFI, MSR and Windows partitions for generation 2.
+func DiskForGeneration(diskID, generation int) (Disk, InstallTo) {
+	if generation == 2 {
+		return Disk{
+			ID:           diskID,
+			WillWipeDisk: true,
+			Partitions: []Partition{
+				{Type: "EFI", SizeMB: 100, Format: "FAT32", Label: "System"},
+				{Type: "MSR", SizeMB: 16},
+				{Type: "Primary", Extend: true, Format: "NTFS", Label: "Windows", Letter: "C"},
+			},
+		}, InstallTo{DiskID: diskID, PartitionID: 3}
+	}
+
+	return Disk{
+		ID:           diskID,
+		WillWipeDisk: true,
+		Partitions: []Partition{
+			{Type: "Primary", SizeMB: 500, Format: "NTFS", Label: "System Reserved", Active: true},
+			{Type: "Primary", Extend: true, Format: "NTFS", Label: "Windows", Letter: "C"},
+		},
+	}, InstallTo{DiskID: diskID, PartitionID: 2}
+}
+
+// SetDiskConfiguration replaces the disks Setup partitions in the
+// windowsPE pass and sets where Windows is installed.
+func (d *Document) SetDiskConfiguration(disks []Disk, installTo InstallTo) {
+	setup := d.EnsureComponent(PassWindowsPE, ComponentSetup)
+
+	diskConfiguration := setup.Ensure("DiskConfiguration")
+	diskConfiguration.Remove("Disk")
+	for _, disk := range disks {
+		addDisk(diskConfiguration, disk)
+	}
+
+	target := setup.Ensure("ImageInstall", "OSImage", "InstallTo")
+	target.Ensure("DiskID").SetText(strconv.Itoa(installTo.DiskID))
+	target.Ensure("PartitionID").SetText(strconv.Itoa(installTo.PartitionID))
+}
+
+func addDisk(diskConfiguration *Element, disk Disk) {
+	e := diskConfiguration.Add("Disk")
+	e.SetAttribute(WcmNamespace, "action", "add")
+	e.Add("DiskID").SetText(strconv.Itoa(disk.ID))
+	e.Add("WillWipeDisk").SetText(strconv.FormatBool(disk.WillWipeDisk))
+
+	createPartitions := e.Add("CreatePartitions")
+	var modifyPartitions *Element
+	for i, partition := range disk.Partitions {
+		order := strconv.Itoa(i + 1)
+
+		create := createPartitions.Add("CreatePartition")
+		create.SetAttribute(WcmNamespace, "action", "add")
+		create.Add("Order").SetText(order)
+		create.Add("Type").SetText(partition.Type)
+		if partition.Extend {
+			create.Add("Extend").SetText("true")
+		} else {
+			create.Add("Size").SetText(strconv.Itoa(partition.SizeMB))
+		}
+
+		if partition.Format == "" && partition.Label == "" && partition.Letter == "" && !partition.Active {
+			continue
+		}
+
+		if modifyPartitions == nil {
+			modifyPartitions = e.Add("ModifyPartitions")
+		}
+		modify := modifyPartitions.Add("ModifyPartition")
+		modify.SetAttribute(WcmNamespace, "action", "add")
+		modify.Add("Order").SetText(order)
+		modify.Add("PartitionID").SetText(order)
+		if partition.Format != "" {
+			modify.Add("Format").SetText(partition.Format)
+		}
+		if partition.Label != "" {
+			modify.Add("Label").SetText(partition.Label)
+		}
+		if partition.Letter != "" {
+			modify.Add("Letter").SetText(partition.Letter)
+		}
+		if partition.Active {
+			modify.Add("Active").SetText("true")
+		}
+	}
+}
+
+// AddFirstLogonCommand appends a command run the first time a user logs
+// on, after the ones already in the file. Use SetAutoLogon for the
+// commands to run unattended.
+func (d *Document) AddFirstLogonCommand(commandLine, description string) {
+	commands := d.EnsureComponent(PassOOBESystem, ComponentShellSetup).Ensure("FirstLogonCommands")
+
+	order := 1
+	for _, command := range commands.ChildrenNamed("SynchronousCommand") {
+		if o := command.Child("Order"); o != nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(o.Text)); err == nil && n >= order {
+				order = n + 1
+			}
+		}
+	}
+
+	command := commands.Add("SynchronousCommand")
+	command.SetAttribute(WcmNamespace, "action", "add")
+	command.Add("Order").SetText(strconv.Itoa(order))
+	command.Add("CommandLine").SetText(commandLine)
+	if description != "" {
+		command.Add("Description").SetText(description)
+	}
+}
+
+// FirstLogonCommands returns the command lines run at first logon, in the
+// order they are run.
+func (d *Document) FirstLogonCommands() []string {
+	shell := d.Component(PassOOBESystem, ComponentShellSetup)
+	if shell == nil {
+		return nil
+	}
+	commands := shell.Child("FirstLogonCommands")
+	if commands == nil {
+		return nil
+	}
+
+	elements := commands.ChildrenNamed("SynchronousCommand")
+	order := func(command *Element) int {
+		n := 0
+		if o := command.Child("Order"); o != nil {
+			n, _ = strconv.Atoi(strings.TrimSpace(o.Text))
+		}
+		return n
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return order(elements[i]) < order(elements[j])
+	})
+
+	commandLines := make([]string, len(elements))
+	for i, command := range elements {
+		if commandLine := command.Child("CommandLine"); commandLine != nil {
+			commandLines[i] = commandLine.Text
+		}
+	}
+	return commandLines
+}
+
+// EnableWinRM adds first logon commands that turn on PowerShell remoting,
+// so that psremote can reach the machine once it is installed. With useSSL
+// an HTTPS listener with a self-signed certificate is added as well.
+func (d *Document) EnableWinRM(useSSL bool) {
+	d.AddFirstLogonCommand(`powershell.exe -NoProfile -ExecutionPolicy Bypass -Command "Get-NetConnectionProfile | Set-NetConnectionProfile -NetworkCategory Private"`,
+		"Allow WinRM through the firewall on every network")
+	d.AddFirstLogonCommand(`powershell.exe -NoProfile -ExecutionPolicy Bypass -Command "Enable-PSRemoting -Force -SkipNetworkProfileCheck"`,
+		"Enable PowerShell remoting")
+
+	if useSSL {
+		d.AddFirstLogonCommand(`powershell.exe -NoProfile -ExecutionPolicy Bypass -Command "$cert = New-SelfSignedCertificate -DnsName $env:COMPUTERNAME -CertStoreLocation Cert:\LocalMachine\My; New-Item -Path WSMan:\localhost\Listener -Transport HTTPS -Address * -CertificateThumbPrint $cert.Thumbprint -Force; New-NetFirewallRule -DisplayName 'Windows Remote Management (HTTPS-In)' -Direction Inbound -Protocol TCP -LocalPort 5986 -Action Allow"`,
+			"Add a WinRM HTTPS listener")
+	}
+}
+
+// setPassword stores password under e the way Windows System Image
+// Manager hides it: base64 of the UTF-16LE password followed by suffix.
+func setPassword(e *Element, password, suffix string) {
+	encoded := utf16.Encode([]rune(password + suffix))
+	data := make([]byte, 2*len(encoded))
+	for i, unit := range encoded {
+		data[2*i] = byte(unit)
+		data[2*i+1] = byte(unit >> 8)
+	}
+
+	e.Ensure("Value").SetText(base64.StdEncoding.EncodeToString(data))
+	e.Ensure("PlainText").SetText("false")
+}
+
+func getPassword(e *Element, suffix string) (string, error) {
+	if e == nil || e.Child("Value") == nil {
+		return "", nil
+	}
+
+	value := strings.TrimSpace(e.Child("Value").Text)
+	if plainText := e.Child("PlainText"); plainText == nil || strings.TrimSpace(plainText.Text) != "false" {
+		return value, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data)%2 != 0 {
+		return "", errors.New("unattend: password is not validly encoded")
+	}
+	password := decodeUTF16LE(data)
+	if !strings.HasSuffix(password, suffix) {
+		return "", errors.New("unattend: password is not validly encoded")
+	}
+	return strings.TrimSuffix(password, suffix), nil
+}
@@ -0,0 +1,257 @@
+// Package unattend reads, edits and writes Windows answer files,
+// unattend.xml and autounattend.xml, without needing Windows.
+//
+// A Document is a tree of Elements that keeps everything it does not know
+// about, so a file written by Windows System Image Manager can be edited
+// and saved again. The Set and Add methods cover the settings psremote
+// provisioning needs. Validate checks the structure Windows Setup expects.
+package unattend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// Namespaces used by answer files.
+const (
+	Namespace    = "urn:schemas-microsoft-com:unattend"
+	WcmNamespace = "http://schemas.microsoft.com/WMIConfig/2002/State"
+	XsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// knownPrefixes are the prefixes Windows System Image Manager uses, and
+// are chosen when an element or attribute needs a namespace declared.
+var knownPrefixes = map[string]string{
+	WcmNamespace: "wcm",
+	XsiNamespace: "xsi",
+}
+
+// DefaultArchitecture is the processorArchitecture of new components when
+// the document has none to copy it from.
+const DefaultArchitecture = "amd64"
+
+// Pass is a configuration pass of Windows Setup.
+type Pass string
+
+const (
+	PassWindowsPE        Pass = "windowsPE"
+	PassOfflineServicing Pass = "offlineServicing"
+	PassGeneralize       Pass = "generalize"
+	PassSpecialize       Pass = "specialize"
+	PassAuditSystem      Pass = "auditSystem"
+	PassAuditUser        Pass = "auditUser"
+	PassOOBESystem       Pass = "oobeSystem"
+)
+
+// Passes lists every pass in the order Windows Setup runs them.
+var Passes = []Pass{
+	PassWindowsPE,
+	PassOfflineServicing,
+	PassGeneralize,
+	PassSpecialize,
+	PassAuditSystem,
+	PassAuditUser,
+	PassOOBESystem,
+}
+
+// Document is a parsed answer file.
+type Document struct {
+	Root *Element
+}
+
+// New returns an answer file without any settings.
+func New() *Document {
+	root := NewElement("unattend")
+	root.Attr = []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: Namespace}}
+	return &Document{Root: root}
+}
+
+// Parse reads an answer file from r. Files saved as UTF-16 with a byte
+// order mark, as some Windows tools do, are accepted.
+func Parse(r io.Reader) (*Document, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(toUTF8(data)))
+	d.CharsetReader = charsetReader
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil, errors.New("unattend: no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			root, err := parseElement(d, start)
+			if err != nil {
+				return nil, err
+			}
+			return &Document{Root: root}, nil
+		}
+	}
+}
+
+// Load reads the answer file at path.
+func Load(path string) (*Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Bytes returns the document as UTF-8 XML.
+func (d *Document) Bytes() []byte {
+	var w writer
+	w.b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	w.element(d.Root, 0, map[string]string{})
+	return []byte(w.b.String())
+}
+
+// WriteTo writes the document to w.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(d.Bytes())
+	return int64(n), err
+}
+
+// Save writes the document to path, keeping the file's mode if it exists.
+func (d *Document) Save(path string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+	return ioutil.WriteFile(path, d.Bytes(), mode)
+}
+
+// Settings returns the settings element for pass, or nil.
+func (d *Document) Settings(pass Pass) *Element {
+	for _, settings := range d.Root.ChildrenNamed("settings") {
+		if settings.Attribute("", "pass") == string(pass) {
+			return settings
+		}
+	}
+	return nil
+}
+
+// EnsureSettings returns the settings element for pass, adding it in pass
+// order when it is missing.
+func (d *Document) EnsureSettings(pass Pass) *Element {
+	if settings := d.Settings(pass); settings != nil {
+		return settings
+	}
+
+	settings := NewElement("settings")
+	settings.SetAttribute("", "pass", string(pass))
+
+	// Insert before the first settings of a later pass.
+	at := len(d.Root.Children)
+	for i, child := range d.Root.Children {
+		if child.Name.Space == Namespace && child.Name.Local == "settings" && passIndex(Pass(child.Attribute("", "pass"))) > passIndex(pass) {
+			at = i
+			break
+		}
+	}
+
+	d.Root.Children = append(d.Root.Children, nil)
+	copy(d.Root.Children[at+1:], d.Root.Children[at:])
+	d.Root.Children[at] = settings
+	return settings
+}
+
+// Component returns the component called name in pass, or nil.
+func (d *Document) Component(pass Pass, name string) *Element {
+	settings := d.Settings(pass)
+	if settings == nil {
+		return nil
+	}
+	for _, component := range settings.ChildrenNamed("component") {
+		if component.Attribute("", "name") == name {
+			return component
+		}
+	}
+	return nil
+}
+
+// EnsureComponent returns the component called name in pass, adding it
+// and its pass when they are missing.
+func (d *Document) EnsureComponent(pass Pass, name string) *Element {
+	if component := d.Component(pass, name); component != nil {
+		return component
+	}
+
+	component := d.EnsureSettings(pass).Add("component")
+	component.Attr = []xml.Attr{
+		{Name: xml.Name{Local: "name"}, Value: name},
+		{Name: xml.Name{Local: "processorArchitecture"}, Value: d.architecture()},
+		{Name: xml.Name{Local: "publicKeyToken"}, Value: "31bf3856ad364e35"},
+		{Name: xml.Name{Local: "language"}, Value: "neutral"},
+		{Name: xml.Name{Local: "versionScope"}, Value: "nonSxS"},
+		{Name: xml.Name{Space: "xmlns", Local: "wcm"}, Value: WcmNamespace},
+		{Name: xml.Name{Space: "xmlns", Local: "xsi"}, Value: XsiNamespace},
+	}
+	return component
+}
+
+// architecture returns the processorArchitecture of the first component,
+// so that components added to a file match the ones already in it.
+func (d *Document) architecture() string {
+	for _, settings := range d.Root.ChildrenNamed("settings") {
+		for _, component := range settings.ChildrenNamed("component") {
+			if arch := component.Attribute("", "processorArchitecture"); arch != "" {
+				return arch
+			}
+		}
+	}
+	return DefaultArchitecture
+}
+
+func passIndex(pass Pass) int {
+	for i, p := range Passes {
+		if p == pass {
+			return i
+		}
+	}
+	return len(Passes)
+}
+
+// toUTF8 converts a UTF-16LE document with a byte order mark to UTF-8 and
+// drops a UTF-8 byte order mark.
+func toUTF8(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return []byte(decodeUTF16LE(data[2:]))
+	}
+	return data
+}
+
+func decodeUTF16LE(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+	return string(utf16.Decode(units))
+}
+
+// charsetReader lets a declaration name UTF-16 once toUTF8 has converted
+// the document.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if strings.HasPrefix(strings.ToLower(charset), "utf-16") {
+		return input, nil
+	}
+	return nil, errors.New("unattend: unsupported charset " + charset)
+}
@@ -0,0 +1,211 @@
+package unattend
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// sample is written in the form Bytes writes, so it survives a round
+// trip unchanged.
+const sample = "testdata/autounattend.xml"
+
+func loadSample(t *testing.T) (*Document, []byte) {
+	data, err := ioutil.ReadFile(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc, data
+}
+
+func TestRoundTrip(t *testing.T) {
+	doc, data := loadSample(t)
+
+	if got := doc.Bytes(); !bytes.Equal(got, data) {
+		t.Errorf("round trip changed the file:\n%s", got)
+	}
+
+	disk := doc.Component(PassWindowsPE, ComponentSetup).Find("DiskConfiguration", "Disk")
+	if disk == nil || disk.Attribute(WcmNamespace, "action") != "add" {
+		t.Fatalf("Disk element %+v", disk)
+	}
+	command := doc.Component(PassOOBESystem, ComponentShellSetup).Find("FirstLogonCommands", "SynchronousCommand", "CommandLine")
+	if command == nil || command.Text != `cmd /c echo a & b > c:\log.txt` {
+		t.Errorf("CommandLine %+v", command)
+	}
+}
+
+func TestCommentsAreDropped(t *testing.T) {
+	doc, err := Parse(strings.NewReader(`<unattend xmlns="urn:schemas-microsoft-com:unattend"><!-- keep? --><settings pass="specialize" /></unattend>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := string(doc.Bytes()); strings.Contains(out, "keep?") || !strings.Contains(out, `<settings pass="specialize" />`) {
+		t.Errorf("written as\n%s", out)
+	}
+}
+
+func TestParseUTF16(t *testing.T) {
+	_, data := loadSample(t)
+	text := strings.Replace(string(data), `encoding="utf-8"`, `encoding="utf-16"`, 1)
+
+	utf16Data := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(text)) {
+		utf16Data = append(utf16Data, byte(u), byte(u>>8))
+	}
+
+	doc, err := Parse(bytes.NewReader(utf16Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Component(PassOOBESystem, ComponentShellSetup) == nil {
+		t.Error("UTF-16 file lost its settings")
+	}
+	if got := doc.Bytes(); !bytes.Equal(got, data) {
+		t.Errorf("UTF-16 file is not written back as UTF-8:\n%s", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		`<?xml version="1.0"?>`,
+		`<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings>`,
+		`<?xml version="1.0" encoding="iso-8859-1"?><unattend />`,
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) succeeded", input)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	doc, _ := loadSample(t)
+	if err := doc.Validate(); err != nil {
+		t.Errorf("sample is invalid: %v", err)
+	}
+	if err := New().Validate(); err != nil {
+		t.Errorf("New is invalid: %v", err)
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	component := `<component name="Microsoft-Windows-Shell-Setup" processorArchitecture="amd64" publicKeyToken="31bf3856ad364e35" language="neutral" versionScope="nonSxS" xmlns:wcm="http://schemas.microsoft.com/WMIConfig/2002/State">`
+
+	for _, c := range []struct {
+		name    string
+		xml     string
+		problem string
+	}{
+		{"wrong root namespace", `<unattend xmlns="urn:example" />`,
+			"root element must be unattend in urn:schemas-microsoft-com:unattend"},
+		{"no namespace", `<unattend />`,
+			"root element must be unattend in urn:schemas-microsoft-com:unattend"},
+		{"unknown pass", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="firstBoot" /></unattend>`,
+			`settings has unknown pass "firstBoot"`},
+		{"repeated pass", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="specialize" /><settings pass="specialize" /></unattend>`,
+			"pass specialize appears more than once"},
+		{"unexpected element", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><extra /></unattend>`,
+			"unexpected element extra under unattend"},
+		{"setting in another namespace", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="specialize">` + component + `<x:ComputerName xmlns:x="urn:example">web</x:ComputerName></component></settings></unattend>`,
+			"ComputerName in component Microsoft-Windows-Shell-Setup is not in the unattend namespace"},
+		{"unknown action", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="specialize">` + component + `<RunSynchronous wcm:action="replace" /></component></settings></unattend>`,
+			`RunSynchronous in component Microsoft-Windows-Shell-Setup has unknown wcm:action "replace"`},
+		{"unknown architecture", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="specialize">` + strings.Replace(component, "amd64", "mips", 1) + `</component></settings></unattend>`,
+			`component Microsoft-Windows-Shell-Setup in pass specialize has unknown processorArchitecture "mips"`},
+		{"component in wrong pass", `<unattend xmlns="urn:schemas-microsoft-com:unattend"><settings pass="specialize">` + strings.Replace(component, "Shell-Setup", "Setup", 1) + `</component></settings></unattend>`,
+			"component Microsoft-Windows-Setup is not read in pass specialize"},
+	} {
+		doc, err := Parse(strings.NewReader(c.xml))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		err = doc.Validate()
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: error %v, want a *ValidationError", c.name, err)
+			continue
+		}
+		found := false
+		for _, problem := range validationErr.Problems {
+			found = found || problem == c.problem
+		}
+		if !found {
+			t.Errorf("%s: problems %q, want %q", c.name, validationErr.Problems, c.problem)
+		}
+	}
+}
+
+func TestSetProductKey(t *testing.T) {
+	doc, _ := loadSample(t)
+
+	doc.SetProductKey("AAAAA-BBBBB-CCCCC-DDDDD-EEEEE")
+	doc.SetProductKey("VK7JG-NPHTM-C97JM-9MPGT-3V66T")
+
+	shell := doc.Component(PassSpecialize, ComponentShellSetup)
+	if shell == nil {
+		t.Fatal("no Shell-Setup component in specialize")
+	}
+	if keys := shell.ChildrenNamed("ProductKey"); len(keys) != 1 || keys[0].Text != "VK7JG-NPHTM-C97JM-9MPGT-3V66T" {
+		t.Errorf("specialize product keys %+v", keys)
+	}
+	key := doc.Component(PassWindowsPE, ComponentSetup).Find("UserData", "ProductKey", "Key")
+	if key == nil || key.Text != "VK7JG-NPHTM-C97JM-9MPGT-3V66T" {
+		t.Errorf("Setup product key %+v", key)
+	}
+	if doc.ProductKey() != "VK7JG-NPHTM-C97JM-9MPGT-3V66T" {
+		t.Errorf("ProductKey() = %s", doc.ProductKey())
+	}
+
+	// The specialize pass is added between windowsPE and oobeSystem, and
+	// what was there is kept.
+	var passes []string
+	for _, settings := range doc.Root.ChildrenNamed("settings") {
+		passes = append(passes, settings.Attribute("", "pass"))
+	}
+	if strings.Join(passes, " ") != "windowsPE specialize oobeSystem" {
+		t.Errorf("passes %v", passes)
+	}
+	if doc.Component(PassWindowsPE, ComponentSetup).Find("UserData", "AcceptEula") == nil {
+		t.Error("AcceptEula was lost")
+	}
+	if err := doc.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	again, err := Parse(bytes.NewReader(doc.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ProductKey() != "VK7JG-NPHTM-C97JM-9MPGT-3V66T" {
+		t.Error("product key was not written")
+	}
+}
+
+func TestSetProductKeyNewDocument(t *testing.T) {
+	doc := New()
+	doc.SetProductKey("VK7JG-NPHTM-C97JM-9MPGT-3V66T")
+
+	// Without windowsPE only the specialize key is set.
+	if doc.Settings(PassWindowsPE) != nil {
+		t.Error("windowsPE pass was added")
+	}
+	shell := doc.Component(PassSpecialize, ComponentShellSetup)
+	if shell == nil || shell.Attribute("", "processorArchitecture") != DefaultArchitecture {
+		t.Fatalf("component %+v", shell)
+	}
+	if doc.ProductKey() != "VK7JG-NPHTM-C97JM-9MPGT-3V66T" {
+		t.Errorf("ProductKey() = %s", doc.ProductKey())
+	}
+	if err := doc.Validate(); err != nil {
+		t.Error(err)
+	}
+}
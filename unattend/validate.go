@@ -0,0 +1,143 @@
+package unattend
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cpiNamespace is used by the offlineImage element Windows System Image
+// Manager adds.
+const cpiNamespace = "urn:schemas-microsoft-com:cpi"
+
+var architectures = map[string]bool{
+	"x86":   true,
+	"amd64": true,
+	"arm":   true,
+	"arm64": true,
+	"ia64":  true,
+	"wow64": true,
+}
+
+var wcmActions = map[string]bool{
+	"add":    true,
+	"modify": true,
+	"remove": true,
+}
+
+// componentPasses limits the components this package writes to the passes
+// Windows Setup reads them in.
+var componentPasses = map[string][]Pass{
+	ComponentSetup:                  {PassWindowsPE},
+	ComponentInternationalCoreWinPE: {PassWindowsPE},
+}
+
+// ValidationError lists everything Validate found wrong with a document.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "unattend: invalid answer file: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the document against the structure of the unattend
+// namespace: the root element, settings passes, component identities and
+// wcm:action values. It does not check the settings inside components
+// against each component's schema. The error is a *ValidationError.
+func (d *Document) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	root := d.Root
+	if root == nil || root.Name.Space != Namespace || root.Name.Local != "unattend" {
+		problem("root element must be unattend in %s", Namespace)
+		return &ValidationError{Problems: problems}
+	}
+
+	passes := map[Pass]bool{}
+	for _, child := range root.Children {
+		switch {
+		case child.Name.Space == Namespace && child.Name.Local == "servicing":
+		case child.Name.Space == cpiNamespace && child.Name.Local == "offlineImage":
+		case child.Name.Space == Namespace && child.Name.Local == "settings":
+			pass := Pass(child.Attribute("", "pass"))
+			if passIndex(pass) == len(Passes) {
+				problem("settings has unknown pass %q", pass)
+				continue
+			}
+			if passes[pass] {
+				problem("pass %s appears more than once", pass)
+			}
+			passes[pass] = true
+			validateSettings(child, pass, problem)
+		default:
+			problem("unexpected element %s under unattend", child.Name.Local)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func validateSettings(settings *Element, pass Pass, problem func(string, ...interface{})) {
+	components := map[string]bool{}
+	for _, component := range settings.Children {
+		if component.Name.Space != Namespace || component.Name.Local != "component" {
+			problem("unexpected element %s in pass %s", component.Name.Local, pass)
+			continue
+		}
+
+		name := component.Attribute("", "name")
+		if name == "" {
+			problem("component without a name in pass %s", pass)
+			continue
+		}
+		for _, attr := range []string{"publicKeyToken", "language", "versionScope"} {
+			if component.Attribute("", attr) == "" {
+				problem("component %s in pass %s has no %s", name, pass, attr)
+			}
+		}
+
+		arch := component.Attribute("", "processorArchitecture")
+		if !architectures[arch] {
+			problem("component %s in pass %s has unknown processorArchitecture %q", name, pass, arch)
+		}
+		if components[name+" "+arch] {
+			problem("component %s appears more than once in pass %s", name, pass)
+		}
+		components[name+" "+arch] = true
+
+		if allowed, ok := componentPasses[name]; ok && !containsPass(allowed, pass) {
+			problem("component %s is not read in pass %s", name, pass)
+		}
+
+		validateElements(component, name, problem)
+	}
+}
+
+// validateElements checks the settings below a component are in the
+// unattend namespace and use known wcm:action values.
+func validateElements(e *Element, component string, problem func(string, ...interface{})) {
+	for _, child := range e.Children {
+		if child.Name.Space != Namespace {
+			problem("%s in component %s is not in the unattend namespace", child.Name.Local, component)
+		}
+		if action := child.Attribute(WcmNamespace, "action"); action != "" && !wcmActions[action] {
+			problem("%s in component %s has unknown wcm:action %q", child.Name.Local, component, action)
+		}
+		validateElements(child, component, problem)
+	}
+}
+
+func containsPass(passes []Pass, pass Pass) bool {
+	for _, p := range passes {
+		if p == pass {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package psremote
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/nimerix/psremote/winrm"
+)
+
+// Transport selects how remote scripts reach ComputerName.
+type Transport int
+
+const (
+	// TransportPowerShell wraps remote scripts in Invoke-Command and runs
+	// them through a local PowerShell. This is the default.
+	TransportPowerShell Transport = iota
+	// TransportWinRM sends remote scripts straight to the WS-Management
+	// service of ComputerName, so no local PowerShell is needed. It only
+	// supports Basic authentication with a user name and password, which
+	// the service must allow (winrm set winrm/config/service/auth
+	// @{Basic="true"}). Without UseSSL the service must also allow
+	// unencrypted traffic (@{AllowUnencrypted="true"}), and the password
+	// crosses the network in the clear, so use it over HTTP only on
+	// trusted networks. Other options fail before anything is sent.
+	TransportWinRM
+	// TransportSSH runs remote scripts through a local PowerShell 7 with
+	// Invoke-Command -HostName, so ComputerName needs sshd with the
+	// PowerShell subsystem rather than WinRM. The user name of the
+	// credential, Port and KeyFilePath are passed to ssh; passwords and
+	// the WinRM options are not used.
+	TransportSSH
+)
+
+var usingPattern = regexp.MustCompile(`(?i)\$using:`)
+
+// outputNative runs scriptBlock on ComputerName through the native WinRM
+// client. The script and its parameters are sent on stdin so that they are
+// not bound by the command line length limit.
+func (ps *PSRemote) outputNative(ctx context.Context, scriptBlock string, params map[string]interface{}) (*Result, error) {
+
+	if err := ps.checkNativeOptions(); err != nil {
+		return nil, err
+	}
+
+	// There is no Invoke-Command on this path, the parameters are
+	// already local to the remote script.
+	script := paramPreamble + wrapErrors(usingPattern.ReplaceAllString(scriptBlock, "$$"))
+
+	serialized, err := serializeParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	ps.logDebug("PowerShell script", "script", script)
+
+	command := &Command{
+		Path:   "powershell.exe",
+		Args:   []string{"-NoProfile", "-NonInteractive", "-EncodedCommand", encodeCommand(stdinBootstrap)},
+		Script: script,
+		Params: params,
+		Stdin:  strings.NewReader(base64.StdEncoding.EncodeToString([]byte(invokeScript(script, serialized))) + "\n"),
+	}
+
+	executor := ps.Executor
+	if executor == nil {
+		credential, err := ps.credential(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if credential.UserName == "" || credential.Password == "" {
+			return nil, fmt.Errorf("%w: TransportWinRM needs a user name and password for Basic authentication", ErrUnsupportedOption)
+		}
+		executor = ps.winrmExecutor(credential)
+	}
+
+	return ps.run(ctx, executor, command)
+}
+
+func (ps *PSRemote) winrmExecutor(credential Credential) Executor {
+	client := winrm.NewClient(ps.ComputerName, ps.Port, ps.UseSSL, credential.UserName, credential.Password)
+	client.HTTPClient = ps.nativeHTTPClient()
+	client.OperationTimeout = ps.SessionOptions.OperationTimeout
+	if ps.ApplicationName != "" {
+		client.Endpoint = strings.TrimSuffix(client.Endpoint, "/wsman") + "/" + ps.ApplicationName
+	}
+	return winrmExecutor{client: client, computerName: ps.ComputerName}
+}
+
+// stdinBootstrap reads a base64 encoded script from the first line of
+// stdin and invokes it. Parameters are passed within the script, see
+// invokeScript, so that they stay off the command line too.
+const stdinBootstrap = `$script = [Console]::In.ReadLine()
+& ([ScriptBlock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($script))))`
+
+// encodeCommand encodes script for powershell -EncodedCommand.
+func encodeCommand(script string) string {
+	units := utf16.Encode([]rune(script))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		b[2*i] = byte(u)
+		b[2*i+1] = byte(u >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+type winrmExecutor struct {
+	client       *winrm.Client
+	computerName string
+}
+
+func (e winrmExecutor) Run(ctx context.Context, cmd *Command) error {
+	exitCode, err := e.client.Run(ctx, cmd.Stdin, cmd.Stdout, cmd.Stderr, cmd.Path, cmd.Args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		var httpErr *winrm.HTTPError
+		auth := errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized
+		return &ConnectionError{ComputerName: e.computerName, Authentication: auth, Err: err}
+	}
+	if exitCode != 0 {
+		return &ExitError{Code: exitCode}
+	}
+	return nil
+}
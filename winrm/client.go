@@ -0,0 +1,357 @@
+// Package winrm is a small WS-Management client for running commands in a
+// remote Windows shell over HTTP or HTTPS, without a local PowerShell.
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultHTTPPort  = 5985
+	DefaultHTTPSPort = 5986
+
+	defaultOperationTimeout = 60 * time.Second
+	defaultMaxEnvelopeSize  = 153600
+
+	// stdinChunkSize keeps each Send comfortably inside the default
+	// MaxEnvelopeSize once base64 encoded.
+	stdinChunkSize = 32 * 1024
+)
+
+// HTTPError is returned when the WS-Management service answers with an
+// HTTP status that carries no SOAP fault, such as 401 Unauthorized.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return "winrm: " + e.Status
+}
+
+// Client talks to the WS-Management service of a single host.
+type Client struct {
+	Endpoint string
+	UserName string
+	Password string
+
+	// HTTPClient is used for every request. When nil http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+
+	// OperationTimeout limits each WS-Management operation. Zero means
+	// 60 seconds.
+	OperationTimeout time.Duration
+	MaxEnvelopeSize  int
+	Locale           string
+}
+
+// Endpoint returns the WS-Management URL for host. A zero port selects
+// the default port for the scheme.
+func Endpoint(host string, port int, useSSL bool) string {
+	scheme := "http"
+	if port == 0 {
+		port = DefaultHTTPPort
+	}
+	if useSSL {
+		scheme = "https"
+		if port == DefaultHTTPPort {
+			port = DefaultHTTPSPort
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/wsman"
+}
+
+// NewClient returns a client for host authenticating with HTTP basic
+// authentication when userName is set.
+func NewClient(host string, port int, useSSL bool, userName, password string) *Client {
+	return &Client{
+		Endpoint: Endpoint(host, port, useSSL),
+		UserName: userName,
+		Password: password,
+	}
+}
+
+// Run executes command in a new shell, feeding it stdin and copying its
+// output streams, and returns the exit code once it finishes. The shell
+// is deleted before Run returns. If ctx is cancelled the command is
+// terminated and ctx.Err() is returned.
+func (c *Client) Run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, command string, args ...string) (int, error) {
+	shell, err := c.CreateShell(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		cleanup, cancel := context.WithTimeout(context.Background(), c.operationTimeout())
+		defer cancel()
+		shell.Close(cleanup)
+	}()
+
+	cmd, err := shell.Execute(ctx, command, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	if stdin != nil {
+		if err := cmd.SendAll(ctx, stdin); err != nil {
+			return 0, cmd.abort(ctx, err)
+		}
+	}
+
+	exitCode, err := cmd.Wait(ctx, stdout, stderr)
+	if err != nil {
+		return 0, cmd.abort(ctx, err)
+	}
+
+	return exitCode, nil
+}
+
+// CreateShell opens a new remote cmd shell.
+func (c *Client) CreateShell(ctx context.Context) (*Shell, error) {
+	m := c.message(ActionCreate, "")
+	m.options = []option{
+		{"WINRS_NOPROFILE", "TRUE"},
+		{"WINRS_CODEPAGE", "65001"},
+	}
+
+	resp, err := c.post(ctx, m.envelope(createShellBody()))
+	if err != nil {
+		return nil, err
+	}
+
+	id := resp.shellID()
+	if id == "" {
+		return nil, fmt.Errorf("winrm: create shell response did not contain a shell id")
+	}
+
+	return &Shell{client: c, ID: id}, nil
+}
+
+func (c *Client) message(action, shellID string) message {
+	locale := c.Locale
+	if locale == "" {
+		locale = "en-US"
+	}
+	maxEnvelope := c.MaxEnvelopeSize
+	if maxEnvelope == 0 {
+		maxEnvelope = defaultMaxEnvelopeSize
+	}
+
+	return message{
+		to:          c.Endpoint,
+		action:      action,
+		shellID:     shellID,
+		timeout:     duration(c.operationTimeout()),
+		maxEnvelope: maxEnvelope,
+		locale:      locale,
+	}
+}
+
+func (c *Client) operationTimeout() time.Duration {
+	if c.OperationTimeout > 0 {
+		return c.OperationTimeout
+	}
+	return defaultOperationTimeout
+}
+
+func (c *Client) post(ctx context.Context, envelope []byte) (*Response, error) {
+	req, err := http.NewRequest("POST", c.Endpoint, bytes.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if c.UserName != "" {
+		req.SetBasicAuth(c.UserName, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	httpResp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp Response
+	if len(body) > 0 {
+		if err := xml.Unmarshal(body, &resp); err != nil && httpResp.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("winrm: invalid response: %s", err)
+		}
+	}
+
+	if resp.Body.Fault != nil {
+		return nil, resp.Body.Fault
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: string(body)}
+	}
+
+	return &resp, nil
+}
+
+// Shell is an open remote shell.
+type Shell struct {
+	client *Client
+	ID     string
+}
+
+// Execute starts command in the shell without waiting for it.
+func (s *Shell) Execute(ctx context.Context, command string, args ...string) (*Command, error) {
+	m := s.client.message(ActionCommand, s.ID)
+	m.options = []option{
+		{"WINRS_CONSOLEMODE_STDIN", "TRUE"},
+		{"WINRS_SKIP_CMD_SHELL", "TRUE"},
+	}
+
+	resp, err := s.client.post(ctx, m.envelope(commandBody(command, args)))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Body.CommandID == "" {
+		return nil, fmt.Errorf("winrm: command response did not contain a command id")
+	}
+
+	return &Command{shell: s, ID: resp.Body.CommandID}, nil
+}
+
+// Close deletes the shell on the remote host.
+func (s *Shell) Close(ctx context.Context) error {
+	m := s.client.message(ActionDelete, s.ID)
+	_, err := s.client.post(ctx, m.envelope(""))
+	return err
+}
+
+// Command is a command running in a remote shell.
+type Command struct {
+	shell *Shell
+	ID    string
+}
+
+// Send writes data to the command's stdin. end closes stdin.
+func (c *Command) Send(ctx context.Context, data []byte, end bool) error {
+	m := c.shell.client.message(ActionSend, c.shell.ID)
+	encoded := base64.StdEncoding.EncodeToString(data)
+	_, err := c.shell.client.post(ctx, m.envelope(sendBody(c.ID, encoded, end)))
+	return err
+}
+
+// SendAll copies r to the command's stdin and closes it.
+func (c *Command) SendAll(ctx context.Context, r io.Reader) error {
+	buf := make([]byte, stdinChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return c.Send(ctx, buf[:n], true)
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.Send(ctx, buf[:n], false); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive fetches the next batch of output. done is true once the
+// command has exited, in which case exitCode is valid.
+func (c *Command) Receive(ctx context.Context, stdout, stderr io.Writer) (exitCode int, done bool, err error) {
+	m := c.shell.client.message(ActionReceive, c.shell.ID)
+	m.options = []option{{"WSMAN_CMDSHELL_OPTION_KEEPALIVE", "TRUE"}}
+
+	resp, err := c.shell.client.post(ctx, m.envelope(receiveBody(c.ID)))
+	if fault, ok := err.(*Fault); ok && fault.TimedOut() {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, stream := range resp.Body.Streams {
+		if stream.Data == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(stream.Data)
+		if err != nil {
+			return 0, false, fmt.Errorf("winrm: invalid %s stream data: %s", stream.Name, err)
+		}
+
+		var w io.Writer
+		switch stream.Name {
+		case "stdout":
+			w = stdout
+		case "stderr":
+			w = stderr
+		}
+		if w != nil {
+			if _, err := w.Write(data); err != nil {
+				return 0, false, err
+			}
+		}
+	}
+
+	if state := resp.Body.State; state != nil && state.State == CommandStateDone {
+		return state.ExitCode, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// Wait receives output until the command exits and returns its exit code.
+func (c *Command) Wait(ctx context.Context, stdout, stderr io.Writer) (int, error) {
+	for {
+		exitCode, done, err := c.Receive(ctx, stdout, stderr)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			return exitCode, nil
+		}
+	}
+}
+
+// Signal sends a signal such as SignalTerminate to the command.
+func (c *Command) Signal(ctx context.Context, code string) error {
+	m := c.shell.client.message(ActionSignal, c.shell.ID)
+	_, err := c.shell.client.post(ctx, m.envelope(signalBody(c.ID, code)))
+	return err
+}
+
+// abort terminates the command after a failure and returns err, or
+// ctx.Err() when the failure was caused by cancellation.
+func (c *Command) abort(ctx context.Context, err error) error {
+	cleanup, cancel := context.WithTimeout(context.Background(), c.shell.client.operationTimeout())
+	defer cancel()
+	c.Signal(cleanup, SignalTerminate)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// duration formats d as an xs:duration.
+func duration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
@@ -0,0 +1,201 @@
+package winrm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nimerix/psremote/winrm"
+	"github.com/nimerix/psremote/winrm/winrmtest"
+)
+
+func newClient(srv *winrmtest.Server) *winrm.Client {
+	client := winrm.NewClient(srv.Host, srv.Port, false, "admin", "secret")
+	client.Endpoint = srv.URL
+	client.HTTPClient = srv.Client()
+	return client
+}
+
+func newServer(fn winrmtest.CommandFunc) *winrmtest.Server {
+	srv := winrmtest.NewServer(fn)
+	srv.UserName, srv.Password = "admin", "secret"
+	return srv
+}
+
+func TestRun(t *testing.T) {
+	var gotCommand string
+	var gotArgs []string
+	var gotStdin []byte
+	srv := newServer(func(command string, args []string, stdin []byte) winrmtest.Result {
+		gotCommand, gotArgs, gotStdin = command, args, stdin
+		return winrmtest.Result{Stdout: "out\r\n", Stderr: "err\r\n", ExitCode: 3}
+	})
+	defer srv.Close()
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := newClient(srv).Run(context.Background(), strings.NewReader("input"), &stdout, &stderr, "powershell.exe", "-NoProfile", "-Command", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exitCode != 3 {
+		t.Errorf("exit code %d, want 3", exitCode)
+	}
+	if stdout.String() != "out\r\n" || stderr.String() != "err\r\n" {
+		t.Errorf("stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+	if gotCommand != "powershell.exe" || !reflect.DeepEqual(gotArgs, []string{"-NoProfile", "-Command", "-"}) {
+		t.Errorf("ran %s %v", gotCommand, gotArgs)
+	}
+	if string(gotStdin) != "input" {
+		t.Errorf("stdin %q, want input", gotStdin)
+	}
+
+	want := []string{winrm.ActionCreate, winrm.ActionCommand, winrm.ActionSend, winrm.ActionReceive, winrm.ActionDelete}
+	if actions := srv.Actions(); !reflect.DeepEqual(actions, want) {
+		t.Errorf("actions %v, want %v", actions, want)
+	}
+	if srv.OpenShells() != 0 {
+		t.Errorf("%d shells left open", srv.OpenShells())
+	}
+}
+
+func TestShell(t *testing.T) {
+	var gotStdin []byte
+	srv := newServer(func(command string, args []string, stdin []byte) winrmtest.Result {
+		gotStdin = stdin
+		return winrmtest.Result{Stdout: "done"}
+	})
+	defer srv.Close()
+
+	ctx := context.Background()
+	shell, err := newClient(srv).CreateShell(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell.ID == "" {
+		t.Fatal("shell has no id")
+	}
+
+	cmd, err := shell.Execute(ctx, "cmd.exe", "/c", "more")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Large input is sent in several chunks.
+	input := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := cmd.SendAll(ctx, bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	exitCode, err := cmd.Wait(ctx, &stdout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 0 || stdout.String() != "done" {
+		t.Errorf("exit code %d, stdout %q", exitCode, stdout.String())
+	}
+	if !bytes.Equal(gotStdin, input) {
+		t.Errorf("received %d bytes of stdin, want %d", len(gotStdin), len(input))
+	}
+
+	if err := cmd.Signal(ctx, winrm.SignalCtrlC); err != nil {
+		t.Fatal(err)
+	}
+	if err := shell.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if srv.OpenShells() != 0 {
+		t.Errorf("%d shells left open", srv.OpenShells())
+	}
+
+	sends := 0
+	for _, action := range srv.Actions() {
+		if action == winrm.ActionSend {
+			sends++
+		}
+	}
+	if sends < 2 {
+		t.Errorf("stdin sent in %d requests, want several", sends)
+	}
+
+	// The shell is gone, so the service answers with a fault.
+	_, err = shell.Execute(ctx, "cmd.exe")
+	var fault *winrm.Fault
+	if !errors.As(err, &fault) {
+		t.Errorf("error %v, want a *winrm.Fault", err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := newServer(func(command string, args []string, stdin []byte) winrmtest.Result {
+		close(started)
+		<-release
+		return winrmtest.Result{}
+	})
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := newClient(srv).Run(ctx, nil, nil, nil, "cmd.exe")
+	if err != context.Canceled {
+		t.Fatalf("error %v, want context.Canceled", err)
+	}
+
+	actions := srv.Actions()
+	if len(actions) < 2 || actions[len(actions)-2] != winrm.ActionSignal || actions[len(actions)-1] != winrm.ActionDelete {
+		t.Errorf("actions %v, want the command terminated and the shell deleted", actions)
+	}
+	if srv.OpenShells() != 0 {
+		t.Errorf("%d shells left open", srv.OpenShells())
+	}
+}
+
+func TestAuthenticationFailure(t *testing.T) {
+	srv := newServer(nil)
+	defer srv.Close()
+
+	client := newClient(srv)
+	client.Password = "wrong"
+
+	_, err := client.Run(context.Background(), nil, nil, nil, "cmd.exe")
+	var httpErr *winrm.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 401 {
+		t.Fatalf("error %v, want a 401 *winrm.HTTPError", err)
+	}
+	if n := len(srv.Actions()); n != 0 {
+		t.Errorf("%d requests were served", n)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := winrmtest.NewTLSServer(func(command string, args []string, stdin []byte) winrmtest.Result {
+		return winrmtest.Result{Stdout: "secure"}
+	})
+	defer srv.Close()
+
+	client := winrm.NewClient(srv.Host, srv.Port, true, "", "")
+	client.HTTPClient = srv.Client()
+	if client.Endpoint != srv.URL {
+		t.Fatalf("endpoint %s, want %s", client.Endpoint, srv.URL)
+	}
+
+	var stdout bytes.Buffer
+	if _, err := client.Run(context.Background(), nil, &stdout, nil, "hostname"); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "secure" {
+		t.Errorf("stdout %q, want secure", stdout.String())
+	}
+}
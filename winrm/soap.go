@@ -0,0 +1,237 @@
+package winrm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const (
+	nsSoap       = "http://www.w3.org/2003/05/soap-envelope"
+	nsAddressing = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	nsWsman      = "http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"
+	nsWsmanMS    = "http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"
+	nsShell      = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
+
+	ResourceURICmd = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+
+	ActionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	ActionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	ActionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	ActionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	ActionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	ActionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	SignalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	SignalCtrlC     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c"
+
+	CommandStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	anonymousAddress = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+
+	// wsmanTimedOut is the WSManFault code returned when a Receive
+	// produced no output within the operation timeout.
+	wsmanTimedOut = "2150858793"
+)
+
+type option struct {
+	name  string
+	value string
+}
+
+// message describes the header of an outgoing request.
+type message struct {
+	to          string
+	action      string
+	shellID     string
+	timeout     string
+	maxEnvelope int
+	locale      string
+	options     []option
+}
+
+func (m message) envelope(body string) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(`<env:Envelope xmlns:env="` + nsSoap + `" xmlns:a="` + nsAddressing + `" xmlns:w="` + nsWsman + `" xmlns:p="` + nsWsmanMS + `" xmlns:rsp="` + nsShell + `">`)
+	b.WriteString(`<env:Header>`)
+	b.WriteString(`<a:To>` + escape(m.to) + `</a:To>`)
+	b.WriteString(`<a:ReplyTo><a:Address env:mustUnderstand="true">` + anonymousAddress + `</a:Address></a:ReplyTo>`)
+	fmt.Fprintf(&b, `<w:MaxEnvelopeSize env:mustUnderstand="true">%d</w:MaxEnvelopeSize>`, m.maxEnvelope)
+	b.WriteString(`<a:MessageID>uuid:` + newUUID() + `</a:MessageID>`)
+	b.WriteString(`<w:Locale xml:lang="` + escape(m.locale) + `" env:mustUnderstand="false"/>`)
+	b.WriteString(`<p:DataLocale xml:lang="` + escape(m.locale) + `" env:mustUnderstand="false"/>`)
+	b.WriteString(`<w:OperationTimeout>` + m.timeout + `</w:OperationTimeout>`)
+	b.WriteString(`<w:ResourceURI env:mustUnderstand="true">` + ResourceURICmd + `</w:ResourceURI>`)
+	b.WriteString(`<a:Action env:mustUnderstand="true">` + m.action + `</a:Action>`)
+	if m.shellID != "" {
+		b.WriteString(`<w:SelectorSet><w:Selector Name="ShellId">` + escape(m.shellID) + `</w:Selector></w:SelectorSet>`)
+	}
+	if len(m.options) > 0 {
+		b.WriteString(`<w:OptionSet>`)
+		for _, o := range m.options {
+			b.WriteString(`<w:Option Name="` + escape(o.name) + `">` + escape(o.value) + `</w:Option>`)
+		}
+		b.WriteString(`</w:OptionSet>`)
+	}
+	b.WriteString(`</env:Header>`)
+	b.WriteString(`<env:Body>` + body + `</env:Body>`)
+	b.WriteString(`</env:Envelope>`)
+
+	return b.Bytes()
+}
+
+func createShellBody() string {
+	return `<rsp:Shell><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams></rsp:Shell>`
+}
+
+func commandBody(command string, args []string) string {
+	var b strings.Builder
+
+	b.WriteString(`<rsp:CommandLine><rsp:Command>` + escape(command) + `</rsp:Command>`)
+	for _, arg := range args {
+		b.WriteString(`<rsp:Arguments>` + escape(escapeArg(arg)) + `</rsp:Arguments>`)
+	}
+	b.WriteString(`</rsp:CommandLine>`)
+
+	return b.String()
+}
+
+func sendBody(commandID, data string, end bool) string {
+	endAttr := ""
+	if end {
+		endAttr = ` End="true"`
+	}
+	return `<rsp:Send><rsp:Stream Name="stdin" CommandId="` + escape(commandID) + `"` + endAttr + `>` + data + `</rsp:Stream></rsp:Send>`
+}
+
+func receiveBody(commandID string) string {
+	return `<rsp:Receive><rsp:DesiredStream CommandId="` + escape(commandID) + `">stdout stderr</rsp:DesiredStream></rsp:Receive>`
+}
+
+func signalBody(commandID, code string) string {
+	return `<rsp:Signal CommandId="` + escape(commandID) + `"><rsp:Code>` + code + `</rsp:Code></rsp:Signal>`
+}
+
+// Response is the subset of a WS-Management response envelope used by
+// the client and by the winrmtest server.
+type Response struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		ShellID   string     `xml:"Shell>ShellId"`
+		Selectors []Selector `xml:"ResourceCreated>ReferenceParameters>SelectorSet>Selector"`
+		CommandID string     `xml:"CommandResponse>CommandId"`
+		Streams   []Stream   `xml:"ReceiveResponse>Stream"`
+		State     *struct {
+			CommandID string `xml:"CommandId,attr"`
+			State     string `xml:"State,attr"`
+			ExitCode  int    `xml:"ExitCode"`
+		} `xml:"ReceiveResponse>CommandState"`
+		Fault *Fault `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// Selector is a WS-Management selector, such as the ShellId of a shell.
+type Selector struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Stream is a chunk of base64 encoded data on a named shell stream.
+type Stream struct {
+	Name      string `xml:"Name,attr"`
+	CommandID string `xml:"CommandId,attr"`
+	End       bool   `xml:"End,attr"`
+	Data      string `xml:",chardata"`
+}
+
+func (r *Response) shellID() string {
+	if r.Body.ShellID != "" {
+		return strings.TrimSpace(r.Body.ShellID)
+	}
+	for _, s := range r.Body.Selectors {
+		if s.Name == "ShellId" {
+			return strings.TrimSpace(s.Value)
+		}
+	}
+	return ""
+}
+
+// Fault is a SOAP fault returned by the WS-Management service.
+type Fault struct {
+	Code    string `xml:"Code>Value"`
+	Subcode string `xml:"Code>Subcode>Value"`
+	Reason  string `xml:"Reason>Text"`
+	Detail  struct {
+		WSManFault struct {
+			Code    string `xml:"Code,attr"`
+			Machine string `xml:"Machine,attr"`
+			Message string `xml:"Message"`
+		} `xml:"WSManFault"`
+	} `xml:"Detail"`
+}
+
+func (f *Fault) Error() string {
+	msg := strings.TrimSpace(f.Reason)
+	if detail := strings.TrimSpace(f.Detail.WSManFault.Message); detail != "" {
+		msg = detail
+	}
+	if f.Detail.WSManFault.Code != "" {
+		return fmt.Sprintf("winrm fault %s: %s", f.Detail.WSManFault.Code, msg)
+	}
+	return fmt.Sprintf("winrm fault %s: %s", f.Subcode, msg)
+}
+
+// TimedOut reports whether the fault is the operation timeout raised by
+// a Receive that had nothing to return yet.
+func (f *Fault) TimedOut() bool {
+	return f.Detail.WSManFault.Code == wsmanTimedOut || strings.HasSuffix(f.Subcode, ":TimedOut")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// escapeArg quotes an argument following the Windows command line rules
+// used by CommandLineToArgvW.
+func escapeArg(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' {
+			slashes++
+			continue
+		}
+		if c == '"' {
+			b.WriteString(strings.Repeat(`\`, slashes*2+1))
+		} else {
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		b.WriteByte(c)
+		slashes = 0
+	}
+	b.WriteString(strings.Repeat(`\`, slashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
+
+func newUUID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,336 @@
+// Package winrmtest provides a local stand-in for a WS-Management service,
+// for testing code built on the winrm package without a Windows host.
+package winrmtest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nimerix/psremote/winrm"
+)
+
+// Result is the output of a command run by the stand-in server.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// CommandFunc produces the result of a command. args are the raw,
+// still quoted, arguments sent by the client.
+type CommandFunc func(command string, args []string, stdin []byte) Result
+
+// Server is an HTTP server that implements the shell create, command,
+// send, receive, signal and delete operations of WS-Management.
+type Server struct {
+	// URL is the WS-Management endpoint, ending in /wsman.
+	URL  string
+	Host string
+	Port int
+
+	// UserName and Password, when set, are required as HTTP basic
+	// authentication on every request.
+	UserName string
+	Password string
+
+	// CommandFunc answers every command. When nil commands succeed
+	// with no output.
+	CommandFunc CommandFunc
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	shells   map[string]map[string]*command
+	nextID   int
+	requests []string
+}
+
+type command struct {
+	name       string
+	args       []string
+	stdin      []byte
+	terminated bool
+}
+
+// NewServer starts a plain HTTP server.
+func NewServer(fn CommandFunc) *Server {
+	s := &Server{CommandFunc: fn, shells: map[string]map[string]*command{}}
+	s.srv = httptest.NewServer(s)
+	s.init()
+	return s
+}
+
+// NewTLSServer starts an HTTPS server with a self-signed certificate.
+// Client returns an http.Client that trusts it.
+func NewTLSServer(fn CommandFunc) *Server {
+	s := &Server{CommandFunc: fn, shells: map[string]map[string]*command{}}
+	s.srv = httptest.NewTLSServer(s)
+	s.init()
+	return s
+}
+
+func (s *Server) init() {
+	s.URL = s.srv.URL + "/wsman"
+	host, port, _ := net.SplitHostPort(s.srv.Listener.Addr().String())
+	s.Host = host
+	s.Port, _ = strconv.Atoi(port)
+}
+
+// Client returns an http.Client configured for the server.
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Actions returns the WS-Management actions received so far, in order.
+func (s *Server) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// OpenShells returns the number of shells that have not been deleted.
+func (s *Server) OpenShells() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.shells)
+}
+
+type request struct {
+	Header struct {
+		Action    string `xml:"Action"`
+		Selectors []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"SelectorSet>Selector"`
+	} `xml:"Header"`
+	Body struct {
+		Command   string   `xml:"CommandLine>Command"`
+		Arguments []string `xml:"CommandLine>Arguments"`
+		Send      []struct {
+			CommandID string `xml:"CommandId,attr"`
+			Data      string `xml:",chardata"`
+		} `xml:"Send>Stream"`
+		Receive struct {
+			CommandID string `xml:"CommandId,attr"`
+		} `xml:"Receive>DesiredStream"`
+		Signal struct {
+			CommandID string `xml:"CommandId,attr"`
+			Code      string `xml:"Code"`
+		} `xml:"Signal"`
+	} `xml:"Body"`
+}
+
+func (r *request) shellID() string {
+	for _, sel := range r.Header.Selectors {
+		if sel.Name == "ShellId" {
+			return sel.Value
+		}
+	}
+	return ""
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" || req.URL.Path != "/wsman" {
+		http.NotFound(w, req)
+		return
+	}
+
+	if s.UserName != "" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != s.UserName || pass != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="WSMAN"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var r request
+	if err := xml.Unmarshal(data, &r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Header.Action)
+	s.mu.Unlock()
+
+	var body string
+	switch r.Header.Action {
+	case winrm.ActionCreate:
+		body = s.createShell()
+	case winrm.ActionCommand:
+		body, err = s.startCommand(&r)
+	case winrm.ActionSend:
+		body, err = s.send(&r)
+	case winrm.ActionReceive:
+		body, err = s.receive(&r)
+	case winrm.ActionSignal:
+		body, err = s.signal(&r)
+	case winrm.ActionDelete:
+		body, err = s.deleteShell(&r)
+	default:
+		err = fmt.Errorf("unsupported action %q", r.Header.Action)
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, envelope(fault(err.Error())))
+		return
+	}
+	fmt.Fprint(w, envelope(body))
+}
+
+func (s *Server) createShell() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	id := fmt.Sprintf("SHELL-%d", s.nextID)
+	s.shells[id] = map[string]*command{}
+
+	return `<rsp:Shell><rsp:ShellId>` + id + `</rsp:ShellId></rsp:Shell>`
+}
+
+func (s *Server) lookup(r *request, commandID string) (map[string]*command, *command, error) {
+	shell, ok := s.shells[r.shellID()]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown shell %q", r.shellID())
+	}
+	if commandID == "" {
+		return shell, nil, nil
+	}
+	cmd, ok := shell[commandID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown command %q", commandID)
+	}
+	return shell, cmd, nil
+}
+
+func (s *Server) startCommand(r *request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shell, _, err := s.lookup(r, "")
+	if err != nil {
+		return "", err
+	}
+
+	s.nextID++
+	id := fmt.Sprintf("COMMAND-%d", s.nextID)
+	shell[id] = &command{name: r.Body.Command, args: r.Body.Arguments}
+
+	return `<rsp:CommandResponse><rsp:CommandId>` + id + `</rsp:CommandId></rsp:CommandResponse>`, nil
+}
+
+func (s *Server) send(r *request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stream := range r.Body.Send {
+		_, cmd, err := s.lookup(r, stream.CommandID)
+		if err != nil {
+			return "", err
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Data))
+		if err != nil {
+			return "", err
+		}
+		cmd.stdin = append(cmd.stdin, data...)
+	}
+
+	return `<rsp:SendResponse/>`, nil
+}
+
+func (s *Server) receive(r *request) (string, error) {
+	s.mu.Lock()
+	_, cmd, err := s.lookup(r, r.Body.Receive.CommandID)
+	if err != nil {
+		s.mu.Unlock()
+		return "", err
+	}
+	name, args, stdin, terminated := cmd.name, cmd.args, cmd.stdin, cmd.terminated
+	s.mu.Unlock()
+
+	var res Result
+	if s.CommandFunc != nil && !terminated {
+		res = s.CommandFunc(name, args, stdin)
+	}
+
+	id := r.Body.Receive.CommandID
+	var b strings.Builder
+	b.WriteString(`<rsp:ReceiveResponse>`)
+	for _, stream := range []struct{ name, data string }{{"stdout", res.Stdout}, {"stderr", res.Stderr}} {
+		if stream.data != "" {
+			b.WriteString(`<rsp:Stream Name="` + stream.name + `" CommandId="` + id + `">`)
+			b.WriteString(base64.StdEncoding.EncodeToString([]byte(stream.data)))
+			b.WriteString(`</rsp:Stream>`)
+		}
+		b.WriteString(`<rsp:Stream Name="` + stream.name + `" CommandId="` + id + `" End="true"></rsp:Stream>`)
+	}
+	fmt.Fprintf(&b, `<rsp:CommandState CommandId="%s" State="%s"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>`, id, winrm.CommandStateDone, res.ExitCode)
+	b.WriteString(`</rsp:ReceiveResponse>`)
+
+	return b.String(), nil
+}
+
+func (s *Server) signal(r *request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, cmd, err := s.lookup(r, r.Body.Signal.CommandID)
+	if err != nil {
+		return "", err
+	}
+	if r.Body.Signal.Code == winrm.SignalTerminate {
+		cmd.terminated = true
+	}
+
+	return `<rsp:SignalResponse/>`, nil
+}
+
+func (s *Server) deleteShell(r *request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, _, err := s.lookup(r, ""); err != nil {
+		return "", err
+	}
+	delete(s.shells, r.shellID())
+
+	return "", nil
+}
+
+func envelope(body string) string {
+	return `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">` +
+		`<s:Header/><s:Body>` + body + `</s:Body></s:Envelope>`
+}
+
+func fault(msg string) string {
+	var text strings.Builder
+	xml.EscapeText(&text, []byte(msg))
+
+	return `<s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:InvalidParameter</s:Value></s:Subcode></s:Code>` +
+		`<s:Reason><s:Text xml:lang="">` + text.String() + `</s:Text></s:Reason></s:Fault>`
+}
@@ -0,0 +1,85 @@
+package psremote
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nimerix/psremote/winrm/winrmtest"
+)
+
+func newWinRMTestRemote(srv *winrmtest.Server) *PSRemote {
+	return &PSRemote{
+		ComputerName: srv.Host,
+		Port:         srv.Port,
+		UserName:     "admin",
+		Password:     "secret",
+		Transport:    TransportWinRM,
+		HTTPClient:   srv.Client(),
+	}
+}
+
+func TestOutputNativeSendsParamsOnStdin(t *testing.T) {
+	var args []string
+	var stdin []byte
+	srv := winrmtest.NewServer(func(command string, a []string, in []byte) winrmtest.Result {
+		args, stdin = a, in
+		return winrmtest.Result{Stdout: "ok\r\n"}
+	})
+	defer srv.Close()
+	srv.UserName, srv.Password = "admin", "secret"
+
+	ps := newWinRMTestRemote(srv)
+	params := map[string]interface{}{"value": strings.Repeat("x", 100000)}
+
+	out, err := ps.OutputWinRm("$value.Length", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "ok" {
+		t.Errorf("output %q, want ok", out)
+	}
+
+	if n := len(strings.Join(args, " ")); n > 8191 {
+		t.Errorf("command line is %d characters", n)
+	}
+
+	line := strings.SplitN(string(stdin), "\n", 2)[0]
+	script, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		t.Fatalf("stdin: %v", err)
+	}
+	serialized, _ := serializeParams(params)
+	if !strings.Contains(string(script), serialized) {
+		t.Error("parameters are not sent with the script on stdin")
+	}
+	if srv.OpenShells() != 0 {
+		t.Errorf("%d shells left open", srv.OpenShells())
+	}
+}
+
+func TestNativeOptionsFailEarly(t *testing.T) {
+	for name, change := range map[string]func(ps *PSRemote){
+		"default authentication":  func(ps *PSRemote) { ps.Authentication = AuthenticationDefault },
+		"negotiate":               func(ps *PSRemote) { ps.Authentication = AuthenticationNegotiate },
+		"kerberos":                func(ps *PSRemote) { ps.Authentication = AuthenticationKerberos },
+		"configuration name":      func(ps *PSRemote) { ps.ConfigurationName = "JEA" },
+		"proxy authentication":    func(ps *PSRemote) { ps.SessionOptions.ProxyAuthentication = AuthenticationNegotiate },
+		"no password":             func(ps *PSRemote) { ps.Password = "" },
+		"no user name":            func(ps *PSRemote) { ps.UserName = "" },
+		"empty provided password": func(ps *PSRemote) { ps.Credentials = StaticCredentials{UserName: "admin"} },
+	} {
+		srv := winrmtest.NewServer(nil)
+		ps := newWinRMTestRemote(srv)
+		change(ps)
+
+		if _, err := ps.OutputWinRm("hostname", nil); !errors.Is(err, ErrUnsupportedOption) {
+			t.Errorf("%s: error %v, want ErrUnsupportedOption", name, err)
+		}
+		if n := len(srv.Actions()); n != 0 {
+			t.Errorf("%s: %d requests sent", name, n)
+		}
+		srv.Close()
+	}
+}